@@ -1,3 +1,4 @@
+; JMPT is an lc3tools extension, build with: lc3 compile --jmpt os.asm
         .ORIG x0000
 
 ; the TRAP vector table
@@ -641,15 +642,15 @@ TRAP_PUTSP_LOOP
 
     AND R0,R0,#0        ; shift high byte into R0
     ADD R3,R0,#8
-TRAP_PUTSP_S_LOOP
+1:
     ADD R0,R0,R0        ; shift R0 left
     ADD R2,R2,#0        ; move MSB from R2 into R0
-    BRzp TRAP_PUTSP_MSB_0
+    BRzp 2f
     ADD R0,R0,#1
-TRAP_PUTSP_MSB_0
+2:
     ADD R2,R2,R2        ; shift R2 left
     ADD R3,R3,#-1
-    BRp TRAP_PUTSP_S_LOOP
+    BRp 1b
 
     ADD R0,R0,#0        ; if high byte is NUL, quit printing
     BRz TRAP_PUTSP_DONE
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/link"
+)
+
+var arCmd = func() cobra.Command {
+	var list bool
+
+	cmd := cobra.Command{
+		Use:           "ar",
+		Args:          cobra.MinimumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if list {
+				return doListArchive(args[0])
+			}
+			if len(args) < 2 {
+				return fmt.Errorf("no modules given for %s", args[0])
+			}
+			return doArchive(args[0], args[1:])
+		},
+	}
+
+	cmd.Flags().BoolVarP(&list, "list", "t", false,
+		"List members of the archive and the symbols they define")
+
+	return cmd
+}()
+
+func doArchive(arPath string, objPaths []string) error {
+	var objs []link.Object
+	for _, fPath := range objPaths {
+		img, err := readObject(fPath)
+		if err != nil {
+			return err
+		}
+		objs = append(objs, link.Object{Name: filepath.Base(fPath), Image: img})
+	}
+
+	ar, err := link.NewArchive(arPath, objs)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(arPath, func(w io.Writer) error {
+		return link.WriteArchive(w, ar)
+	})
+}
+
+func doListArchive(arPath string) error {
+	ar, err := readArchive(arPath)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range ar.Members {
+		globals := append([]string(nil), obj.Globals...)
+		sort.Strings(globals)
+		fmt.Println(obj.Name)
+		for _, sym := range globals {
+			fmt.Printf("\t%s\n", sym)
+		}
+	}
+
+	return nil
+}
+
+func readArchive(fPath string) (*link.Archive, error) {
+	fp, err := os.Open(fPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errClose := fp.Close(); errClose != nil {
+			log.Printf("[ERR] %s", errClose)
+		}
+	}()
+
+	ar, err := link.ReadArchive(fp, fPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fPath, err)
+	}
+
+	return ar, nil
+}
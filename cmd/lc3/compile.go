@@ -1,64 +1,268 @@
 package main
 
 import (
-	"encoding/json"
-	"github.com/alexey-medvedchikov/lc3/pkg/parser"
-	"log"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/asm"
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+	"github.com/alexey-medvedchikov/lc3/pkg/preproc"
 )
 
 var compileCmd = func() cobra.Command {
 	var outputFile string
+	var format string
+	var symFile string
+	var listingFile string
+	var debugFile string
+	var preprocessOnly bool
+	var includeDirs []string
+	var defines []string
+	var pseudoOps bool
+	var jmpt bool
+	var errorFormat string
+	var relax bool
+	var relocatable bool
 
 	cmd := cobra.Command{
-		Use:  "compile",
-		Args: cobra.ExactArgs(1),
+		Use:           "compile",
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			inputFile := args[0]
-			return doCompile(inputFile, outputFile)
+			if outputFile == "" {
+				outputFile = "image." + format
+				if relocatable {
+					outputFile = strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile)) + ".o"
+				}
+			}
+			imageFormat, err := asm.ParseFormat(format)
+			if err != nil {
+				return err
+			}
+			if errorFormat != "text" && errorFormat != "json" {
+				return fmt.Errorf("unknown error format '%s'", errorFormat)
+			}
+			if relocatable && debugFile != "" {
+				return fmt.Errorf("debug info of relocatable modules is written by lc3 link")
+			}
+			if preprocessOnly {
+				opts, err := preprocOptions(includeDirs, defines)
+				if err != nil {
+					return err
+				}
+				return doPreprocess(inputFile, opts)
+			}
+
+			opts, err := asmOptions(includeDirs, defines)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, asm.WithFormat(imageFormat))
+			if pseudoOps {
+				opts = append(opts, asm.WithPseudoOps())
+			}
+			if jmpt {
+				opts = append(opts, asm.WithJMPT())
+			}
+			if relax {
+				opts = append(opts, asm.WithRelax())
+			}
+			if relocatable {
+				opts = append(opts, asm.WithRelocatable())
+			}
+			err = doCompile(inputFile, opts, relocatable, outputFile, symFile, listingFile, debugFile)
+			var errs asm.ErrorList
+			if errors.As(err, &errs) {
+				return reportErrors(errs, errorFormat)
+			}
+			return err
 		},
 	}
 
-	cmd.Flags().StringVarP(&outputFile, "output", "o", "image.bin",
-		"Output memory image")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "",
+		"Output memory image (default \"image.<format>\")")
+	cmd.Flags().StringVarP(&format, "format", "f", string(asm.FormatObj),
+		"Output format: obj (LC-3 object file, one segment), bin (raw memory image) or seg (several segments)")
+	cmd.Flags().StringVar(&symFile, "sym", "",
+		"Write the symbol table to a .sym file")
+	cmd.Flags().StringVar(&listingFile, "listing", "",
+		"Write the assembler listing to a .lst file")
+	cmd.Flags().StringVar(&debugFile, "debug", "",
+		"Write debug info tying addresses to source lines to a .dbg file, see run --debug")
+	cmd.Flags().BoolVarP(&preprocessOnly, "preprocess", "E", false,
+		"Print the source with macros expanded and exit")
+	cmd.Flags().StringArrayVarP(&includeDirs, "include", "I", nil,
+		"Add a directory to search for .INCLUDE files")
+	cmd.Flags().StringArrayVarP(&defines, "define", "D", nil,
+		"Define a symbol for conditional assembly as NAME or NAME=value")
+	cmd.Flags().BoolVar(&pseudoOps, "pseudo", false,
+		"Enable MOV, CLR, SUB, NEG, INC, DEC, PUSH, POP, CALL and LDIMM pseudo-instructions")
+	cmd.Flags().BoolVar(&jmpt, "jmpt", false,
+		"Enable JMPT, the jump dropping to user mode some LC-3 operating systems use")
+	cmd.Flags().BoolVar(&relax, "relax", false,
+		"Rewrite BR, JSR and LD with out of range targets into longer sequences, relaxed branches clobber R7")
+	cmd.Flags().BoolVarP(&relocatable, "relocatable", "c", false,
+		"Write a relocatable module for lc3 link (default output \"<input>.o\")")
+	cmd.Flags().StringVar(&errorFormat, "error-format", "text",
+		"Error output format: text (with source snippets, to stderr) or json (to stdout)")
 
 	return cmd
 }()
 
-func doCompile(fPath string, outputFile string) error {
-	inFp, err := os.OpenFile(fPath, os.O_RDONLY, 0644)
+func doCompile(
+	fPath string, opts []asm.Option, relocatable bool, outputFile string, symFile string, listingFile string,
+	debugFile string,
+) error {
+	src, err := os.ReadFile(fPath)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if errClose := inFp.Close(); errClose != nil {
-			log.Printf("[ERR] %s", errClose)
-		}
-	}()
 
-	program, err := parser.Parse(inFp)
+	img, err := asm.Assemble(bytes.NewReader(src), append(opts, asm.WithFilename(fPath))...)
 	if err != nil {
 		return err
 	}
+	for _, w := range img.Warnings {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", w.Pos, w.Msg)
+	}
+
+	write := img.Write
+	if relocatable {
+		write = func(w io.Writer) error {
+			return asm.WriteObject(w, img)
+		}
+	}
+	if err := writeFile(outputFile, write); err != nil {
+		return err
+	}
+
+	if symFile != "" {
+		if err := writeFile(symFile, func(w io.Writer) error {
+			return asm.WriteSym(w, img.Symbols)
+		}); err != nil {
+			return err
+		}
+	}
 
-	outFp, err := os.OpenFile(outputFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if listingFile != "" {
+		if err := writeFile(listingFile, func(w io.Writer) error {
+			return asm.WriteListing(w, fPath, src, img)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if debugFile != "" {
+		return writeFile(debugFile, func(w io.Writer) error {
+			return asm.WriteDebugInfo(w, asm.NewDebugInfo(img))
+		})
+	}
+
+	return nil
+}
+
+// errReported tells that errors or unformatted files have already been
+// written out and the command only has to fail
+var errReported = errors.New("assembly failed")
+
+// reportErrors writes source errors in the given format, snippets are read
+// from the files errors point to
+func reportErrors(errs []*asm.Error, format string) error {
+	if format == "json" {
+		if err := asm.WriteErrorsJSON(os.Stdout, errs); err != nil {
+			return err
+		}
+		return errReported
+	}
+
+	sources := map[string][]byte{}
+	for _, e := range errs {
+		if _, ok := sources[e.Pos.Filename]; ok {
+			continue
+		}
+		// a file that cannot be read is reported without snippets
+		src, _ := os.ReadFile(e.Pos.Filename)
+		sources[e.Pos.Filename] = src
+	}
+	if err := asm.WriteErrors(os.Stderr, errs, sources); err != nil {
+		return err
+	}
+
+	return errReported
+}
+
+func doPreprocess(fPath string, opts []preproc.Option) error {
+	src, err := os.ReadFile(fPath)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if errClose := outFp.Close(); errClose != nil {
-			log.Printf("[ERR] %s", errClose)
+
+	lines, err := preproc.Process(fPath, src, opts...)
+	if err != nil {
+		return reportErrors(asm.Errors(err), "text")
+	}
+
+	return preproc.Write(os.Stdout, lines)
+}
+
+// preprocOptions turns -I and -D flags into preprocessor options
+func preprocOptions(includeDirs []string, defines []string) ([]preproc.Option, error) {
+	opts := []preproc.Option{preproc.WithIncludeDirs(includeDirs...)}
+	for _, d := range defines {
+		name, value, err := splitDefine(d)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, preproc.WithDefine(name, value))
+	}
+
+	return opts, nil
+}
+
+// asmOptions turns -I and -D flags into assembler options
+func asmOptions(includeDirs []string, defines []string) ([]asm.Option, error) {
+	opts := []asm.Option{asm.WithIncludeDirs(includeDirs...)}
+	for _, d := range defines {
+		name, value, err := splitDefine(d)
+		if err != nil {
+			return nil, err
 		}
-	}()
+		opts = append(opts, asm.WithDefine(name, value))
+	}
+
+	return opts, nil
+}
 
-	m := json.NewEncoder(outFp)
-	m.SetIndent("", "  ")
-	m.SetEscapeHTML(false)
-	if err := m.Encode(program); err != nil {
+// splitDefine splits NAME=value of a -D flag, a symbol defined without a
+// value is set to 1
+func splitDefine(d string) (string, string, error) {
+	name, value := d, "1"
+	if i := strings.IndexByte(d, '='); i != -1 {
+		name, value = d[:i], d[i+1:]
+	}
+	if !parser.IsLabel(name) {
+		return "", "", fmt.Errorf("invalid symbol name '%s' in -D %s", name, d)
+	}
+
+	return name, value, nil
+}
+
+// writeFile creates the file only once write succeeds so a failure leaves
+// no empty or truncated output behind
+func writeFile(fPath string, write func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
 		return err
 	}
 
-	return nil
+	return os.WriteFile(fPath, buf.Bytes(), 0644)
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/asm"
+	"github.com/alexey-medvedchikov/lc3/pkg/disasm"
+)
+
+var disasmCmd = func() cobra.Command {
+	var outputFile string
+	var format string
+	var symFile string
+	var debugFile string
+	var origin uint16
+
+	cmd := cobra.Command{
+		Use:           "disasm",
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			imagePath := args[0]
+			if format == "" {
+				var err error
+				if format, err = imageFormat(imagePath); err != nil {
+					return err
+				}
+			}
+			var originFlag *uint16
+			if cmd.Flags().Changed("origin") {
+				originFlag = &origin
+			}
+			return doDisasm(imagePath, asm.Format(format), originFlag, symFile, debugFile, outputFile)
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "",
+		"Write the source to a file instead of stdout")
+	cmd.Flags().StringVarP(&format, "format", "f", "",
+		"Image format: obj (LC-3 object file), bin (raw memory image) or seg (several segments) "+
+			"(default from the file extension)")
+	cmd.Flags().Uint16Var(&origin, "origin", 0,
+		"Address a bin image starts at (default the lowest address of --debug or --sym)")
+	cmd.Flags().StringVar(&symFile, "sym", "",
+		"Name addresses after a .sym file")
+	cmd.Flags().StringVar(&debugFile, "debug", "",
+		"Tell code from data with a .dbg file written by compile or link")
+
+	return cmd
+}()
+
+func doDisasm(
+	imagePath string, format asm.Format, origin *uint16, symFile string, debugFile string, outputFile string,
+) error {
+	var opts []disasm.Option
+	var symbols asm.SymbolTable
+	if symFile != "" {
+		var err error
+		if symbols, err = readSym(symFile); err != nil {
+			return err
+		}
+		opts = append(opts, disasm.WithSymbols(symbols))
+	}
+	var info *asm.DebugInfo
+	if debugFile != "" {
+		var err error
+		if info, err = readDebugInfo(debugFile); err != nil {
+			return err
+		}
+		opts = append(opts, disasm.WithDebugInfo(info))
+	}
+
+	if origin != nil && format != asm.FormatBin {
+		return fmt.Errorf("--origin only applies to bin images, obj files record their origins")
+	}
+	if origin == nil && format == asm.FormatBin {
+		addr, err := binOrigin(info, symbols)
+		if err != nil {
+			return err
+		}
+		origin = &addr
+	}
+
+	img, err := readImage(imagePath, format, origin)
+	if err != nil {
+		return err
+	}
+
+	src, err := disasm.Disassemble(img, opts...)
+	if err != nil {
+		return err
+	}
+	if outputFile == "" {
+		_, err := os.Stdout.Write(src)
+		return err
+	}
+
+	return writeFile(outputFile, func(w io.Writer) error {
+		_, err := w.Write(src)
+		return err
+	})
+}
+
+// imageFormat tells the format of an image by its file extension, a bin
+// image read as obj would be taken apart at a bogus origin
+func imageFormat(fPath string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(fPath)); ext {
+	case "." + string(asm.FormatObj), "." + string(asm.FormatBin), "." + string(asm.FormatSeg):
+		return ext[1:], nil
+	}
+
+	return "", fmt.Errorf("cannot tell the format of '%s' by its extension, set it with --format", fPath)
+}
+
+// binOrigin takes the origin of a bin image from the lowest address of its
+// debug info or, failing that, of its symbols
+func binOrigin(info *asm.DebugInfo, symbols asm.SymbolTable) (uint16, error) {
+	var addrs []uint16
+	if info != nil {
+		for _, w := range info.Words {
+			addrs = append(addrs, w.Addr)
+		}
+	} else {
+		for _, addr := range symbols {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return 0, fmt.Errorf("bin images do not record their origin, set it with --origin, --debug or --sym")
+	}
+
+	origin := addrs[0]
+	for _, addr := range addrs[1:] {
+		if addr < origin {
+			origin = addr
+		}
+	}
+
+	return origin, nil
+}
+
+// readImage reads an image in the given format, origin is only used by bin
+// images
+func readImage(fPath string, format asm.Format, origin *uint16) (*asm.Image, error) {
+	fp, err := os.Open(fPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errClose := fp.Close(); errClose != nil {
+			log.Printf("[ERR] %s", errClose)
+		}
+	}()
+
+	var addr uint16
+	if origin != nil {
+		addr = *origin
+	}
+
+	return asm.Read(fp, format, addr)
+}
+
+func readSym(fPath string) (asm.SymbolTable, error) {
+	fp, err := os.Open(fPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errClose := fp.Close(); errClose != nil {
+			log.Printf("[ERR] %s", errClose)
+		}
+	}()
+
+	return asm.ReadSym(fp)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/format"
+)
+
+var fmtCmd = func() cobra.Command {
+	var write bool
+	var check bool
+	var diff bool
+	var pseudoOps bool
+	var jmpt bool
+
+	cmd := cobra.Command{
+		Use:           "fmt",
+		Args:          cobra.MinimumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var opts []format.Option
+			if pseudoOps {
+				opts = append(opts, format.WithPseudoOps())
+			}
+			if jmpt {
+				opts = append(opts, format.WithJMPT())
+			}
+
+			unformatted := false
+			for _, fPath := range args {
+				changed, err := doFormat(fPath, opts, write, check, diff)
+				if err != nil {
+					return err
+				}
+				unformatted = unformatted || changed
+			}
+			if check && unformatted {
+				return errReported
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&write, "write", "w", false,
+		"Write the result back to the source files instead of printing it")
+	cmd.Flags().BoolVar(&check, "check", false,
+		"List files whose formatting differs and fail if there are any")
+	cmd.Flags().BoolVarP(&diff, "diff", "d", false,
+		"Print diffs of the formatting changes instead of the result")
+	cmd.Flags().BoolVar(&pseudoOps, "pseudo", false,
+		"Format MOV, CLR, SUB, NEG, INC, DEC, PUSH, POP, CALL and LDIMM as instructions")
+	cmd.Flags().BoolVar(&jmpt, "jmpt", false,
+		"Format JMPT as an instruction")
+
+	return cmd
+}()
+
+// doFormat formats a single file and reports whether its formatting differs
+func doFormat(fPath string, opts []format.Option, write, check, diff bool) (bool, error) {
+	src, err := os.ReadFile(fPath)
+	if err != nil {
+		return false, err
+	}
+
+	out, err := format.Source(fPath, src, opts...)
+	if err != nil {
+		return false, err
+	}
+	changed := !bytes.Equal(src, out)
+
+	if check && changed {
+		fmt.Println(fPath)
+	}
+	if diff && changed {
+		d, err := format.Diff(fPath, src, out)
+		if err != nil {
+			return false, err
+		}
+		fmt.Print(d)
+	}
+	if write && changed {
+		if err := os.WriteFile(fPath, out, 0644); err != nil {
+			return false, err
+		}
+	}
+	if !write && !check && !diff {
+		if _, err := os.Stdout.Write(out); err != nil {
+			return false, err
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/asm"
+	"github.com/alexey-medvedchikov/lc3/pkg/link"
+)
+
+var linkCmd = func() cobra.Command {
+	var outputFile string
+	var format string
+	var symFile string
+	var debugFile string
+	var layoutFile string
+	var libs []string
+
+	cmd := cobra.Command{
+		Use:           "link",
+		Args:          cobra.MinimumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			imageFormat, err := asm.ParseFormat(format)
+			if err != nil {
+				return err
+			}
+			if outputFile == "" {
+				outputFile = "image." + format
+			}
+			return doLink(args, libs, layoutFile, imageFormat, outputFile, symFile, debugFile)
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "",
+		"Output memory image (default \"image.<format>\")")
+	cmd.Flags().StringVarP(&format, "format", "f", string(asm.FormatObj),
+		"Output format: obj (LC-3 object file, one segment), bin (raw memory image) or seg (several segments)")
+	cmd.Flags().StringVar(&symFile, "sym", "",
+		"Write the symbol table to a .sym file")
+	cmd.Flags().StringVar(&debugFile, "debug", "",
+		"Write debug info tying addresses to source lines to a .dbg file, see run --debug")
+	cmd.Flags().StringVar(&layoutFile, "layout", "",
+		"Place sections into memory regions described by a layout file (default: vectors, system, text and data)")
+	cmd.Flags().StringArrayVarP(&libs, "lib", "l", nil,
+		"Link in members of an archive made with lc3 ar that define undefined symbols")
+
+	return cmd
+}()
+
+func doLink(
+	objPaths []string, libs []string, layoutFile string, format asm.Format, outputFile string, symFile string,
+	debugFile string,
+) error {
+	var opts []link.Option
+	if layoutFile != "" {
+		layout, err := readLayout(layoutFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, link.WithLayout(layout))
+	}
+	for _, fPath := range libs {
+		ar, err := readArchive(fPath)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, link.WithArchives(ar))
+	}
+
+	var objs []link.Object
+	for _, fPath := range objPaths {
+		img, err := readObject(fPath)
+		if err != nil {
+			return err
+		}
+		objs = append(objs, link.Object{Name: fPath, Image: img})
+	}
+
+	img, err := link.Link(objs, opts...)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(outputFile, func(w io.Writer) error {
+		return asm.Write(w, img, format)
+	}); err != nil {
+		return err
+	}
+
+	if symFile != "" {
+		if err := writeFile(symFile, func(w io.Writer) error {
+			return asm.WriteSym(w, img.Symbols)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if debugFile != "" {
+		return writeFile(debugFile, func(w io.Writer) error {
+			return asm.WriteDebugInfo(w, asm.NewDebugInfo(img))
+		})
+	}
+
+	return nil
+}
+
+func readObject(fPath string) (*asm.Image, error) {
+	fp, err := os.Open(fPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errClose := fp.Close(); errClose != nil {
+			log.Printf("[ERR] %s", errClose)
+		}
+	}()
+
+	img, err := asm.ReadObject(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fPath, err)
+	}
+
+	return img, nil
+}
+
+func readLayout(fPath string) (link.Layout, error) {
+	fp, err := os.Open(fPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errClose := fp.Close(); errClose != nil {
+			log.Printf("[ERR] %s", errClose)
+		}
+	}()
+
+	return link.ParseLayout(fp, fPath)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/asm"
+	"github.com/alexey-medvedchikov/lc3/pkg/lint"
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+	"github.com/alexey-medvedchikov/lc3/pkg/preproc"
+)
+
+var lintCmd = func() cobra.Command {
+	var includeDirs []string
+	var defines []string
+	var pseudoOps bool
+	var jmpt bool
+	var disabled []string
+	var listRules bool
+
+	cmd := cobra.Command{
+		Use:           "lint",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if listRules {
+				printRules()
+				return nil
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("no files to lint")
+			}
+			for _, id := range disabled {
+				if !lint.IsRule(id) {
+					return fmt.Errorf("unknown lint rule '%s'", id)
+				}
+			}
+
+			opts, err := preprocOptions(includeDirs, defines)
+			if err != nil {
+				return err
+			}
+			var parseOpts []parser.Option
+			if pseudoOps {
+				parseOpts = append(parseOpts, parser.WithPseudoOps())
+			}
+			if jmpt {
+				parseOpts = append(parseOpts, parser.WithJMPT())
+			}
+
+			found := false
+			for _, fPath := range args {
+				warnings, err := doLint(fPath, opts, parseOpts, disabled)
+				if err != nil {
+					return err
+				}
+				found = found || len(warnings) != 0
+				for _, w := range warnings {
+					fmt.Printf("%s: warning: %s [%s]\n", w.Pos, w.Msg, w.Rule)
+				}
+			}
+			if found {
+				return errReported
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&includeDirs, "include", "I", nil,
+		"Add a directory to search for .INCLUDE files")
+	cmd.Flags().StringArrayVarP(&defines, "define", "D", nil,
+		"Define a symbol for conditional assembly as NAME or NAME=value")
+	cmd.Flags().BoolVar(&pseudoOps, "pseudo", false,
+		"Enable MOV, CLR, SUB, NEG, INC, DEC, PUSH, POP, CALL and LDIMM pseudo-instructions")
+	cmd.Flags().BoolVar(&jmpt, "jmpt", false,
+		"Enable JMPT, the jump dropping to user mode some LC-3 operating systems use")
+	cmd.Flags().StringArrayVar(&disabled, "disable", nil,
+		"Turn a rule off, see --rules for their IDs")
+	cmd.Flags().BoolVar(&listRules, "rules", false,
+		"List the rules and exit")
+
+	return cmd
+}()
+
+// doLint returns warnings for a file, syntax errors are reported the way
+// compile does
+func doLint(fPath string, opts []preproc.Option, parseOpts []parser.Option, disabled []string) ([]*lint.Warning, error) {
+	src, err := os.ReadFile(fPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// syntax errors of the lines are reported along with preprocessor ones
+	lines, ppErr := preproc.Process(fPath, src, opts...)
+	prog, err := parser.ParseLines(lines, parseOpts...)
+	if ppErr != nil || err != nil {
+		return nil, reportErrors(asm.Errors(ppErr, err), "text")
+	}
+
+	return lint.Check(prog, lint.WithDisabled(disabled...)), nil
+}
+
+func printRules() {
+	rules := lint.Rules()
+	ids := make([]string, 0, len(rules))
+	for id := range rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		fmt.Printf("%-14s %s\n", id, rules[id])
+	}
+}
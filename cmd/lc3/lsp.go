@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/lsp"
+)
+
+var lspCmd = func() cobra.Command {
+	var includeDirs []string
+	var pseudoOps bool
+	var jmpt bool
+
+	cmd := cobra.Command{
+		Use:           "lsp",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := []lsp.Option{lsp.WithIncludeDirs(includeDirs...)}
+			if pseudoOps {
+				opts = append(opts, lsp.WithPseudoOps())
+			}
+			if jmpt {
+				opts = append(opts, lsp.WithJMPT())
+			}
+
+			return lsp.Serve(os.Stdin, os.Stdout, opts...)
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&includeDirs, "include", "I", nil,
+		"Add a directory to search for .INCLUDE files")
+	cmd.Flags().BoolVar(&pseudoOps, "pseudo", false,
+		"Enable MOV, CLR, SUB, NEG, INC, DEC, PUSH, POP, CALL and LDIMM pseudo-instructions")
+	cmd.Flags().BoolVar(&jmpt, "jmpt", false,
+		"Enable JMPT, the jump dropping to user mode some LC-3 operating systems use")
+
+	return cmd
+}()
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -14,8 +16,17 @@ func main() {
 	}
 	rootCmd.AddCommand(&runCmd)
 	rootCmd.AddCommand(&compileCmd)
+	rootCmd.AddCommand(&linkCmd)
+	rootCmd.AddCommand(&arCmd)
+	rootCmd.AddCommand(&fmtCmd)
+	rootCmd.AddCommand(&lintCmd)
+	rootCmd.AddCommand(&lspCmd)
+	rootCmd.AddCommand(&disasmCmd)
 
 	if err := rootCmd.Execute(); err != nil {
+		if errors.Is(err, errReported) {
+			os.Exit(1)
+		}
 		log.Fatalln(err)
 	}
 }
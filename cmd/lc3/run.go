@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -9,12 +10,15 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/alexey-medvedchikov/lc3/pkg/asm"
 	"github.com/alexey-medvedchikov/lc3/pkg/machine"
 )
 
 var runCmd = func() cobra.Command {
 	var startAddr uint16
 	var enableTrace bool
+	var format string
+	var debugFile string
 
 	cmd := cobra.Command{
 		Use:  "run",
@@ -22,20 +26,32 @@ var runCmd = func() cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			imagePath := args[0]
 
-			return doRun(imagePath, startAddr, enableTrace)
+			return doRun(imagePath, asm.Format(format), startAddr, enableTrace, debugFile)
 		},
 	}
 
 	cmd.Flags().Uint16VarP(&startAddr, "start-addr", "s", machine.UserStart,
 		"Initial Program Counter value")
 	cmd.Flags().BoolVarP(&enableTrace, "trace", "t", false, "Enable tracing")
+	cmd.Flags().StringVar(&debugFile, "debug", "",
+		"Show source lines and symbols in the trace, read from a .dbg file written by compile or link")
+	cmd.Flags().StringVarP(&format, "format", "f", string(asm.FormatObj),
+		"Image format: obj (LC-3 object file), bin (raw memory image) or seg (several segments)")
 
 	return cmd
 }()
 
-func doRun(imagePath string, startAddr uint16, enableTrace bool) error {
+func doRun(imagePath string, format asm.Format, startAddr uint16, enableTrace bool, debugFile string) error {
 	var m machine.Machine
 
+	var debugInfo *asm.DebugInfo
+	if debugFile != "" {
+		var err error
+		if debugInfo, err = readDebugInfo(debugFile); err != nil {
+			return err
+		}
+	}
+
 	fp, err := os.OpenFile(imagePath, os.O_RDONLY, 0)
 	if err != nil {
 		return err
@@ -46,14 +62,8 @@ func doRun(imagePath string, startAddr uint16, enableTrace bool) error {
 		}
 	}()
 
-	w := machine.NewMemoryWriter(&m.Memory)
-	written, err := io.Copy(w, fp)
-	log.Printf("[INFO] %d bytes written", written)
-	if err != nil {
-		if !errors.Is(err, io.ErrShortWrite) {
-			return err
-		}
-		log.Printf("[WARN] input is too big, truncated to memory size")
+	if err := loadImage(&m.Memory, fp, format); err != nil {
+		return err
 	}
 
 	log.Printf("[INFO] starting VM (PC = 0x%0.4x)", startAddr)
@@ -65,6 +75,14 @@ func doRun(imagePath string, startAddr uint16, enableTrace bool) error {
 			time.Sleep(1 * time.Second)
 		}
 		t := machine.NewTracedMachine(os.Stdout, &m)
+		if debugInfo != nil {
+			t.SetSource(func(addr uint16) string {
+				if w, ok := debugInfo.Lookup(addr); ok {
+					return fmt.Sprintf("x%04X %s", addr, w)
+				}
+				return fmt.Sprintf("x%04X", addr)
+			})
+		}
 		t.Start(traceFunc)
 	} else {
 		m.Start()
@@ -72,3 +90,48 @@ func doRun(imagePath string, startAddr uint16, enableTrace bool) error {
 
 	return nil
 }
+
+func loadImage(mem *machine.Memory, r io.Reader, format asm.Format) error {
+	switch format {
+	case asm.FormatObj, asm.FormatSeg:
+		img, err := asm.Read(r, format, 0)
+		if err != nil {
+			return err
+		}
+		for _, seg := range img.Segments {
+			if int(seg.Origin)+len(seg.Words) > int(machine.DeviceRegStart) {
+				return fmt.Errorf("segment at 0x%0.4x overlaps device registers", seg.Origin)
+			}
+			mem.WriteSegment(seg.Origin, seg.Words)
+			log.Printf("[INFO] %d words written at 0x%0.4x", len(seg.Words), seg.Origin)
+		}
+	case asm.FormatBin:
+		w := machine.NewMemoryWriter(mem)
+		written, err := io.Copy(w, r)
+		log.Printf("[INFO] %d bytes written", written)
+		if err != nil {
+			if !errors.Is(err, io.ErrShortWrite) {
+				return err
+			}
+			log.Printf("[WARN] input is too big, truncated to memory size")
+		}
+	default:
+		return fmt.Errorf("unknown image format '%s'", format)
+	}
+
+	return nil
+}
+
+func readDebugInfo(fPath string) (*asm.DebugInfo, error) {
+	fp, err := os.Open(fPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errClose := fp.Close(); errClose != nil {
+			log.Printf("[ERR] %s", errClose)
+		}
+	}()
+
+	return asm.ReadDebugInfo(fp)
+}
@@ -0,0 +1,649 @@
+package asm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+	"github.com/alexey-medvedchikov/lc3/pkg/preproc"
+)
+
+type Segment struct {
+	Origin uint16
+	Words  []uint16
+	// Section names the section of a relocatable module the segment belongs
+	// to, see .SECTION, it is empty for segments started with .ORIG
+	Section string `json:",omitempty"`
+}
+
+type Image struct {
+	Segments  []Segment
+	Symbols   SymbolTable
+	SourceMap []SourceEntry
+	// Globals and Relocations describe a relocatable module, see
+	// WithRelocatable
+	Globals     []string     `json:",omitempty"`
+	Relocations []Relocation `json:",omitempty"`
+	// SymbolSegments holds the index of the segment every symbol of a
+	// relocatable module is defined in
+	SymbolSegments map[string]int `json:",omitempty"`
+	// Format is the one Write uses, set with WithFormat
+	Format Format `json:"-"`
+	// Warnings tell about code the assembler changed, such as operations
+	// relaxed WithRelax
+	Warnings []*Error `json:"-"`
+}
+
+// SourceEntry ties a source statement to the address and the words it was
+// assembled into
+type SourceEntry struct {
+	Pos   lexer.Position
+	Addr  uint16
+	Words []uint16
+	// Segment is the index of the segment the statement belongs to
+	Segment int `json:",omitempty"`
+	// Labels holds names of the labels defined on the statement
+	Labels []string `json:",omitempty"`
+	// Code tells instructions from data
+	Code bool `json:",omitempty"`
+}
+
+// SymbolTable maps label names to their resolved addresses
+type SymbolTable map[string]uint16
+
+type Error struct {
+	Pos lexer.Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// Position and Message make errors look like parser ones
+func (e *Error) Position() lexer.Position { return e.Pos }
+func (e *Error) Message() string          { return e.Msg }
+
+// ErrorList holds every error found in a program, in source order
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+func errorf(pos lexer.Position, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+type assembler struct {
+	config
+
+	symbols SymbolTable
+	// symSegs holds the segment index of every symbol
+	symSegs map[string]int
+	// consts holds .EQU and .SET constants, sets marks the ones defined by
+	// .SET which may be redefined
+	consts map[string]value
+	sets   map[string]bool
+	// locals holds definitions of every numeric local label in source order
+	locals map[parser.LocalLabel][]localDef
+	// addrs holds the address of every statement, filled by the first pass
+	addrs []uint16
+	// segs holds segment origins from the first pass, words are emitted
+	// by the second one
+	segs      []Segment
+	sourceMap []SourceEntry
+	// pools holds the literal pool of every segment
+	pools []literalPool
+
+	// relaxed marks far operations replaced with their long forms
+	relaxed  map[int]bool
+	warnings []*Error
+
+	// externs and globals hold symbols declared with .EXTERNAL and .GLOBAL
+	externs map[string]lexer.Position
+	globals map[string]lexer.Position
+	relocs  []Relocation
+
+	pc  uint16
+	seg *Segment
+	// segIdx is the index of the current segment
+	segIdx int
+	pool   *literalPool
+	// stmt is the index of the statement being assembled, local label
+	// references are resolved relative to it
+	stmt int
+
+	errs []stmtError
+	// failed marks statements the first pass reported errors for, the
+	// second pass skips them
+	failed map[int]bool
+}
+
+type stmtError struct {
+	stmt int
+	err  *Error
+}
+
+type localDef struct {
+	stmt int
+	addr uint16
+	seg  int
+}
+
+// literalPool holds 16-bit values pseudo-instructions load with LD, it is
+// placed at the end of its segment
+type literalPool struct {
+	addr  uint16
+	size  int
+	words []uint16
+}
+
+// span is a memory range [start, end) occupied by a segment
+type span struct {
+	pos        lexer.Position
+	start, end int
+}
+
+// Assemble reads, preprocesses, parses and assembles a program. All errors
+// found in the source are returned as an ErrorList, failures to read src
+// as they are.
+func Assemble(src io.Reader, opts ...Option) (*Image, error) {
+	c := newConfig(opts)
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, ppErr := preproc.Process(c.filename, data, c.preproc...)
+
+	// the program is assembled even if some lines failed to parse to report
+	// errors of the rest of them as well
+	prog, parseErr := parser.ParseLines(lines, c.parserOptions()...)
+	if prog == nil {
+		return nil, ErrorList(Errors(ppErr, parseErr))
+	}
+	// lines of a program the preprocessor failed on are only checked for
+	// syntax errors, assembling them would report errors caused by the
+	// missing parts
+	if ppErr != nil {
+		return nil, ErrorList(Errors(ppErr, parseErr))
+	}
+	img, err := c.assemble(prog)
+	if parseErr != nil || err != nil {
+		return nil, ErrorList(Errors(parseErr, err))
+	}
+
+	return img, nil
+}
+
+// AssembleProgram translates a parsed program into machine code, options
+// of the preprocessor have no effect here.
+func AssembleProgram(prog *parser.Program, opts ...Option) (*Image, error) {
+	return newConfig(opts).assemble(prog)
+}
+
+// assemble makes two passes over the program. The first pass assigns an
+// address to every statement and builds the symbol table, the second one
+// encodes operations and data using the resolved addresses.
+func (c config) assemble(prog *parser.Program) (*Image, error) {
+	a := assembler{config: c, relaxed: map[int]bool{}}
+
+	// with relaxation the first pass is repeated until every operation
+	// reaches its target, relaxed operations only grow so it ends
+	for {
+		a.reset(len(prog.Statements))
+		if !a.firstPass(prog) {
+			return nil, a.errList()
+		}
+		if !a.relax || len(a.errs) != 0 || !a.relaxFar(prog) {
+			break
+		}
+	}
+	img := a.secondPass(prog)
+	if len(a.errs) != 0 {
+		return nil, a.errList()
+	}
+
+	return img, nil
+}
+
+func (a *assembler) reset(n int) {
+	a.symbols = SymbolTable{}
+	a.symSegs = map[string]int{}
+	a.consts = map[string]value{}
+	a.sets = map[string]bool{}
+	a.locals = map[parser.LocalLabel][]localDef{}
+	a.addrs = make([]uint16, n)
+	a.segs = nil
+	a.pools = nil
+	a.errs = nil
+	a.failed = map[int]bool{}
+	a.externs = map[string]lexer.Position{}
+	a.globals = map[string]lexer.Position{}
+	a.relocs = nil
+}
+
+// fail records an error of the current statement, assembly goes on to find
+// more of them
+func (a *assembler) fail(err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Msg: err.Error()}
+	}
+	a.errs = append(a.errs, stmtError{stmt: a.stmt, err: e})
+	a.failed[a.stmt] = true
+}
+
+// errList returns recorded errors in the order of statements
+func (a *assembler) errList() ErrorList {
+	sort.SliceStable(a.errs, func(i, j int) bool { return a.errs[i].stmt < a.errs[j].stmt })
+
+	errs := make(ErrorList, len(a.errs))
+	for i, e := range a.errs {
+		errs[i] = e.err
+	}
+
+	return errs
+}
+
+// firstPass returns false when the program is too broken for the second
+// pass to make sense
+func (a *assembler) firstPass(prog *parser.Program) bool {
+	var spans []span
+	var cur *span
+	reported := false
+
+	for i, st := range prog.Statements {
+		a.stmt = i
+
+		switch {
+		case isDirective(st.Directive, ".orig"):
+			if cur != nil {
+				a.fail(errorf(st.Pos, ".END expected before the next .ORIG"))
+				a.closeSegment(cur)
+			}
+			if len(st.Labels) != 0 {
+				a.fail(errorf(st.Labels[0].Pos, "labels are not allowed on .ORIG"))
+			}
+			origin, err := a.origin(st.Directive)
+			a.segIdx = len(a.segs)
+			a.segs = append(a.segs, Segment{Origin: origin})
+			a.pools = append(a.pools, literalPool{})
+			a.pool = &a.pools[len(a.pools)-1]
+			if err != nil {
+				// the segment is still assembled to find errors within but
+				// it takes no part in overlap checks
+				a.fail(err)
+				cur = &span{pos: st.Pos}
+			} else {
+				spans = append(spans, span{pos: st.Pos, start: int(origin), end: int(origin)})
+				cur = &spans[len(spans)-1]
+			}
+			a.pc = origin
+			continue
+		case isDirective(st.Directive, ".section"):
+			// a section ends the previous one, the linker places sections
+			// so their addresses start from 0
+			if cur != nil {
+				a.closeSegment(cur)
+			}
+			name, err := a.section(st)
+			if err != nil {
+				a.fail(err)
+			}
+			a.segIdx = len(a.segs)
+			a.segs = append(a.segs, Segment{Section: name})
+			a.pools = append(a.pools, literalPool{})
+			a.pool = &a.pools[len(a.pools)-1]
+			cur = &span{pos: st.Pos}
+			a.pc = 0
+			continue
+		case isConstant(st.Directive):
+			if err := a.constant(st); err != nil {
+				a.fail(err)
+			}
+			continue
+		case isLinkage(st.Directive):
+			if err := a.linkage(st); err != nil {
+				a.fail(err)
+			}
+			continue
+		case isEmpty(st):
+			continue
+		case cur == nil && len(a.segs) == 0:
+			if !reported {
+				a.fail(errorf(st.Pos, ".ORIG expected before the first statement"))
+				reported = true
+			}
+			continue
+		case cur == nil:
+			// .END stops assembly until the next .ORIG
+			continue
+		}
+
+		a.addrs[i] = a.pc
+
+		for _, l := range st.Labels {
+			if err := a.label(l, i); err != nil {
+				a.fail(err)
+			}
+		}
+
+		if isDirective(st.Directive, ".end") {
+			a.closeSegment(cur)
+			cur = nil
+			continue
+		}
+
+		size, err := a.size(st)
+		if err != nil {
+			a.fail(err)
+			continue
+		}
+		if int(a.pc)+size > int(^uint16(0))+1 {
+			a.fail(errorf(st.Pos, "segment does not fit into memory"))
+			// the rest of the segment is ignored
+			a.closeSegment(cur)
+			cur = nil
+			continue
+		}
+		a.pc += uint16(size)
+		cur.end = int(a.pc)
+	}
+
+	if len(a.segs) == 0 {
+		a.fail(errorf(prog.Pos, "no .ORIG directive found"))
+		return false
+	}
+	if cur != nil {
+		a.closeSegment(cur)
+	}
+	if err := checkOverlaps(spans); err != nil {
+		a.fail(err)
+	}
+	a.checkLinkage()
+
+	return true
+}
+
+// closeSegment places the literal pool at the end of the current segment
+func (a *assembler) closeSegment(cur *span) {
+	if err := a.placePool(cur.pos, cur); err != nil {
+		a.fail(err)
+	}
+}
+
+func (a *assembler) secondPass(prog *parser.Program) *Image {
+	next := 0
+
+	for i, st := range prog.Statements {
+		a.stmt = i
+
+		switch {
+		case isDirective(st.Directive, ".orig"), isDirective(st.Directive, ".section"):
+			if a.seg != nil {
+				a.emit(a.pool.words...)
+			}
+			a.seg = &a.segs[next]
+			a.pool = &a.pools[next]
+			a.segIdx = next
+			next++
+			a.sourceMap = append(a.sourceMap, SourceEntry{Pos: st.Pos, Addr: a.seg.Origin, Segment: a.segIdx})
+			continue
+		case a.failed[i]:
+			continue
+		case isDirective(st.Directive, ".set"):
+			// .SET constants are evaluated again to have the same values
+			// the first pass had at this point
+			if err := a.constant(st); err != nil {
+				a.fail(err)
+			}
+			continue
+		case a.seg == nil, isEmpty(st), isConstant(st.Directive), isLinkage(st.Directive):
+			continue
+		}
+
+		a.pc = a.addrs[i]
+		start := len(a.seg.Words)
+
+		var err error
+		switch {
+		case isDirective(st.Directive, ".end"):
+			a.emit(a.pool.words...)
+		case st.Directive != nil:
+			err = a.directive(st.Directive)
+		case st.Op != nil:
+			err = a.op(st.Op)
+		case st.Trap != nil:
+			a.emit(trapAliases[strings.ToLower(*st.Trap.Name)])
+		}
+		if err != nil {
+			a.fail(err)
+		}
+
+		a.sourceMap = append(a.sourceMap, SourceEntry{
+			Pos:     st.Pos,
+			Addr:    a.pc,
+			Words:   a.seg.Words[start:],
+			Segment: a.segIdx,
+			Labels:  labelNames(st),
+			Code:    st.Op != nil || st.Trap != nil,
+		})
+		if isDirective(st.Directive, ".end") {
+			a.seg = nil
+		}
+	}
+	if a.seg != nil {
+		a.emit(a.pool.words...)
+	}
+
+	return &Image{
+		Segments:       a.segs,
+		Symbols:        a.symbols,
+		SourceMap:      a.sourceMap,
+		Globals:        a.globalNames(),
+		Relocations:    a.relocs,
+		SymbolSegments: a.symbolSegments(),
+		Format:         a.format,
+		Warnings:       a.warnings,
+	}
+}
+
+// placePool reserves space for the literal pool at the end of the segment
+func (a *assembler) placePool(pos lexer.Position, cur *span) error {
+	if int(a.pc)+a.pool.size > int(^uint16(0))+1 {
+		return errorf(pos, "literal pool does not fit into memory")
+	}
+	a.pool.addr = a.pc
+	a.pc += uint16(a.pool.size)
+	cur.end = int(a.pc)
+
+	return nil
+}
+
+// literal places a value into the literal pool and returns its address
+func (a *assembler) literal(v uint16) uint16 {
+	addr := a.pool.addr + uint16(len(a.pool.words))
+	a.pool.words = append(a.pool.words, v)
+
+	return addr
+}
+
+func checkOverlaps(spans []span) error {
+	sorted := make([]span, len(spans))
+	copy(sorted, spans)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+
+	var prev *span
+	for i := range sorted {
+		cur := &sorted[i]
+		if cur.start == cur.end {
+			continue
+		}
+		if prev != nil && cur.start < prev.end {
+			return errorf(cur.pos, "segment x%04X-x%04X overlaps segment x%04X-x%04X defined at %s",
+				cur.start, cur.end-1, prev.start, prev.end-1, prev.pos)
+		}
+		prev = cur
+	}
+
+	return nil
+}
+
+func (a *assembler) emit(words ...uint16) {
+	a.seg.Words = append(a.seg.Words, words...)
+}
+
+func (a *assembler) size(st *parser.Statement) (int, error) {
+	switch {
+	case st.Op != nil:
+		return a.opSize(st.Op)
+	case st.Trap != nil:
+		return 1, nil
+	case st.Directive != nil:
+		return a.directiveSize(st.Directive)
+	}
+
+	return 0, nil
+}
+
+// constant handles "NAME .EQU expression" and "NAME .SET expression", the
+// former defines NAME once while the latter may redefine it later
+func (a *assembler) constant(st *parser.Statement) error {
+	d := st.Directive
+	if len(st.Labels) != 1 || st.Labels[0].Name == nil {
+		return errorf(d.Pos, "%s expects a single label naming the constant", strings.ToUpper(*d.Name))
+	}
+	if len(d.Args) != 1 || d.Args[0].Expr == nil {
+		return errorf(d.Pos, "%s expects a single expression", strings.ToUpper(*d.Name))
+	}
+
+	name := *st.Labels[0].Name
+	set := isDirective(d, ".set")
+	if a.defined(name) && !(set && a.sets[name]) {
+		return errorf(st.Labels[0].Pos, "duplicate label '%s'", name)
+	}
+
+	v, err := a.eval(d.Args[0].Expr)
+	if err != nil {
+		return err
+	}
+	a.consts[name] = v
+	a.sets[name] = set
+
+	return nil
+}
+
+// label defines a label of the statement i at the current address
+func (a *assembler) label(l *parser.Label, i int) error {
+	if l.Local != nil {
+		a.locals[*l.Local] = append(a.locals[*l.Local], localDef{stmt: i, addr: a.pc, seg: a.segIdx})
+		return nil
+	}
+
+	name := *l.Name
+	if _, _, ok := parser.LocalRef(name); ok {
+		return errorf(l.Pos, "label '%s' would be read as a local label reference", name)
+	}
+	if a.defined(name) {
+		return errorf(l.Pos, "duplicate label '%s'", name)
+	}
+	a.symbols[name] = a.pc
+	a.symSegs[name] = a.segIdx
+
+	return nil
+}
+
+// localLookup resolves a reference to the local label n from the current
+// statement, backward references see a definition on the statement itself
+func (a *assembler) localLookup(pos lexer.Position, ref string, n parser.LocalLabel, forward bool) (localDef, error) {
+	defs := a.locals[n]
+	if forward {
+		for _, d := range defs {
+			if d.stmt > a.stmt {
+				return d, nil
+			}
+		}
+	} else {
+		for i := len(defs) - 1; i >= 0; i-- {
+			if defs[i].stmt <= a.stmt {
+				return defs[i], nil
+			}
+		}
+	}
+
+	return localDef{}, errorf(pos, "undefined local label '%s'", ref)
+}
+
+func (a *assembler) defined(name string) bool {
+	_, isSymbol := a.symbols[name]
+	_, isConst := a.consts[name]
+
+	return isSymbol || isConst
+}
+
+func (a *assembler) lookup(pos lexer.Position, name string) (uint16, error) {
+	addr, ok := a.symbols[name]
+	if !ok {
+		return 0, errorf(pos, "undefined label '%s'%s", name, a.suggestLabel(name))
+	}
+
+	return addr, nil
+}
+
+// suggestLabel returns a "did you mean" hint for an undefined name
+func (a *assembler) suggestLabel(name string) string {
+	names := make([]string, 0, len(a.symbols)+len(a.consts))
+	for n := range a.symbols {
+		names = append(names, n)
+	}
+	for n := range a.consts {
+		names = append(names, n)
+	}
+
+	return didYouMean(parser.Suggest(name, names))
+}
+
+func didYouMean(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(", did you mean '%s'?", s)
+}
+
+// labelNames returns names of the labels of a statement, local labels are
+// left out
+func labelNames(st *parser.Statement) []string {
+	var names []string
+	for _, l := range st.Labels {
+		if l.Name != nil {
+			names = append(names, *l.Name)
+		}
+	}
+
+	return names
+}
+
+func isEmpty(st *parser.Statement) bool {
+	return st.Directive == nil && st.Op == nil && st.Trap == nil && len(st.Labels) == 0
+}
+
+func isConstant(d *parser.Directive) bool {
+	return isDirective(d, ".equ") || isDirective(d, ".set")
+}
+
+func isDirective(d *parser.Directive, name string) bool {
+	return d != nil && strings.EqualFold(*d.Name, name)
+}
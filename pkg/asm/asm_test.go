@@ -0,0 +1,355 @@
+package asm
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/bytecode"
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+func assemble(t *testing.T, src string, opts ...Option) (*Image, error) {
+	t.Helper()
+
+	prog, err := parser.Parse(strings.NewReader(src), newConfig(opts).parserOptions()...)
+	require.NoError(t, err)
+
+	return AssembleProgram(prog, opts...)
+}
+
+func TestAssemble(t *testing.T) {
+	img, err := assemble(t, `
+        .ORIG x3000
+        LEA R0, MSG     ; load the message address
+        PUTS
+LOOP    ADD R1, R1, #-1
+        BRp LOOP
+        AND R2, R2, R3
+        LD R3, DATA
+        JSR SUB
+        HALT
+SUB     RET
+DATA    .FILL x1234
+        .FILL SUB
+        .BLKW 2
+MSG     .STRINGZ "Hi"
+        .END
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, SymbolTable{
+		"LOOP": 0x3002,
+		"SUB":  0x3008,
+		"DATA": 0x3009,
+		"MSG":  0x300D,
+	}, img.Symbols)
+
+	require.Len(t, img.Segments, 1)
+	assert.Equal(t, uint16(0x3000), img.Segments[0].Origin)
+	assert.Equal(t, []uint16{
+		bytecode.LEA(bytecode.R0, 12),
+		bytecode.Trap(0x22),
+		bytecode.AddImm(bytecode.R1, bytecode.R1, -1),
+		bytecode.BRx(0b001, -2),
+		bytecode.AndReg(bytecode.R2, bytecode.R2, bytecode.R3),
+		bytecode.LD(bytecode.R3, 3),
+		bytecode.JSR(1),
+		bytecode.Trap(0x25),
+		bytecode.RET(),
+		0x1234,
+		0x3008,
+		0, 0,
+		'H', 'i', 0,
+	}, img.Segments[0].Words)
+}
+
+func TestAssemble_BR(t *testing.T) {
+	img, err := assemble(t, `
+        .ORIG x3000
+L       BR L
+        BRn L
+        BRz L
+        BRp L
+        BRnz L
+        BRnp L
+        BRzp L
+        BRnzp L
+        .END
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []uint16{
+		bytecode.BRx(0b111, -1),
+		bytecode.BRx(0b100, -2),
+		bytecode.BRx(0b010, -3),
+		bytecode.BRx(0b001, -4),
+		bytecode.BRx(0b110, -5),
+		bytecode.BRx(0b101, -6),
+		bytecode.BRx(0b011, -7),
+		bytecode.BRx(0b111, -8),
+	}, img.Segments[0].Words)
+}
+
+func TestAssemble_Segments(t *testing.T) {
+	img, err := assemble(t, `
+        .ORIG x3000
+        LD R0, DATA
+        HALT
+        .END
+
+; data lives in its own segment
+        .ORIG x3100
+DATA    .FILL x0042
+        .END
+
+        .ORIG x0100
+        .FILL x3000
+        .END
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Segment{
+		{Origin: 0x3000, Words: []uint16{bytecode.LD(bytecode.R0, 0xFF), bytecode.Trap(0x25)}},
+		{Origin: 0x3100, Words: []uint16{0x0042}},
+		{Origin: 0x0100, Words: []uint16{0x3000}},
+	}, img.Segments)
+	assert.Equal(t, SymbolTable{"DATA": 0x3100}, img.Symbols)
+}
+
+func TestAssemble_Directives(t *testing.T) {
+	img, err := assemble(t, `
+        .ORIG x3000
+A       .FILL A
+        .FILL #-1
+        .BLKW 2
+        .BLKW 2, x00FF
+        .BLKW 1 A
+        .STRINGZ "a\tb\n\"\\\0"
+        .END
+this text is ignored as .END stops assembly
+        ADD R0, R0, R0
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []uint16{
+		0x3000,
+		0xFFFF,
+		0, 0,
+		0x00FF, 0x00FF,
+		0x3000,
+		'a', '\t', 'b', '\n', '"', '\\', 0, 0,
+	}, img.Segments[0].Words)
+}
+
+func TestAssemble_Reader(t *testing.T) {
+	resolve := func(name, from string) (string, []byte, error) {
+		return name, []byte("TWICE .EQU VALUE*2\n"), nil
+	}
+	src := `
+        .INCLUDE "defs.asm"
+        .ORIG x3000
+.IFDEF DEBUG
+        HALT
+.ENDIF
+        .FILL TWICE
+        PUSH R1
+        .END
+`
+	img, err := Assemble(strings.NewReader(src),
+		WithFilename("main.asm"),
+		WithResolver(resolve),
+		WithDefine("VALUE", "21"),
+		WithPseudoOps(),
+		WithFormat(FormatBin),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, []uint16{42, 0x7380, 0x1DBF}, img.Segments[0].Words)
+	assert.Equal(t, FormatBin, img.Format)
+	assert.Equal(t, "main.asm", img.SourceMap[0].Pos.Filename)
+
+	_, err = Assemble(strings.NewReader("  .ORIG x3000\n  BR NOWHERE\n  ADD R1\n  .END\n"), WithFilename("bad.asm"))
+	require.Error(t, err)
+	errs := err.(ErrorList)
+	require.Len(t, errs, 2)
+	assert.Equal(t, "bad.asm:2:6: undefined label 'NOWHERE'", errs[0].Error())
+}
+
+func TestAssemble_Expressions(t *testing.T) {
+	img, err := assemble(t, `
+SIZE    .EQU 4
+STEP    .SET 1
+        .ORIG x3000
+START   LEA R0, TABLE+2
+        ADD R1, R1, -(SIZE*2)
+        LDR R2, R0, SIZE-1
+        BR END-START-1
+        .FILL END-START
+STEP    .SET STEP+1
+        .FILL STEP
+TABLE   .BLKW SIZE/2, ~0
+        .FILL TABLE+SIZE%3
+END     .END
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []uint16{
+		0xE007, // LEA R0, x3008
+		0x1278, // ADD R1, R1, #-8
+		0x6403, // LDR R2, R0, #3
+		0x0E08, // BR #8
+		0x0009,
+		0x0002,
+		0xFFFF, 0xFFFF,
+		0x3007,
+	}, img.Segments[0].Words)
+	assert.Equal(t, SymbolTable{"START": 0x3000, "TABLE": 0x3006, "END": 0x3009}, img.Symbols)
+}
+
+func TestAssemble_LocalLabels(t *testing.T) {
+	img, err := assemble(t, `
+        .ORIG x3000
+1:      ADD R0, R0, #-1
+        BRp 1b
+        BR 1f
+1:      BRnzp 1b
+1:      .FILL 1b
+        .FILL 1f
+1:      .FILL 2f-1b
+2:      .END
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []uint16{
+		0x103F, // ADD R0, R0, #-1
+		0x03FE, // BRp x3000
+		0x0E00, // BR x3003
+		0x0FFF, // BRnzp x3003 on the same line
+		0x3004,
+		0x3006,
+		0x0001,
+	}, img.Segments[0].Words)
+	assert.Empty(t, img.Symbols)
+}
+
+func TestAssemble_Literals(t *testing.T) {
+	img, err := assemble(t, `
+        .ORIG 0x3000
+        ADD R1, R1, 5
+        AND R1, R1, b11
+        .FILL 0b1010
+        .FILL 'A'
+        .FILL '\0'
+        .BLKW 1, '\n'
+        .END
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []uint16{0x1265, 0x5263, 10, 'A', 0, '\n'}, img.Segments[0].Words)
+}
+
+func TestAssemble_Errors(t *testing.T) {
+	tests := map[string]string{
+		"no origin":       "ADD R0, R0, R0",
+		"undefined label": ".ORIG x3000\nBR NOWHERE\n.END",
+		"duplicate label": ".ORIG x3000\nA ADD R0, R0, R0\nA ADD R0, R0, R0\n.END",
+		"imm5 range":      ".ORIG x3000\nADD R0, R0, #16\n.END",
+		"operand count":   ".ORIG x3000\nNOT R0\n.END",
+		"operand type":    ".ORIG x3000\nLDR R0, #1, #1\n.END",
+		"offset range":    ".ORIG x3000\nBR FAR\n.BLKW 256\nFAR RET\n.END",
+		"trap range":      ".ORIG x3000\nTRAP x100\n.END",
+		"fill string":     ".ORIG x3000\n.FILL \"a\"\n.END",
+		"fill range":      ".ORIG x3000\n.FILL x10000\n.END",
+		"blkw negative":   ".ORIG x3000\n.BLKW #-1\n.END",
+		"blkw args":       ".ORIG x3000\n.BLKW 1, 2, 3\n.END",
+		"stringz number":  ".ORIG x3000\n.STRINGZ 1\n.END",
+		"unknown":         ".ORIG x3000\n.WORD 1\n.END",
+		"before segment":  "; header\nADD R0, R0, R0\n.ORIG x3000\n.END",
+		"nested segment":  ".ORIG x3000\n.ORIG x4000\n.END\n.END",
+		"overlap":         ".ORIG x3000\n.BLKW 16\n.END\n.ORIG x300F\n.FILL 1\n.END",
+		"imm5 expression": ".ORIG x3000\nADD R0, R0, 8*2\n.END",
+		"offset6 range":   ".ORIG x3000\nLDR R0, R0, 32\n.END",
+		"offset11 range":  ".ORIG x3000\nJSR x400\n.END",
+		"label product":   ".ORIG x3000\nA .FILL A*2\n.END",
+		"label sum":       ".ORIG x3000\nA .FILL A+A\n.END",
+		"label imm":       ".ORIG x3000\nA ADD R0, R0, A\n.END",
+		"division":        ".ORIG x3000\n.FILL 1/0\n.END",
+		"forward blkw":    ".ORIG x3000\n.BLKW N\nN .EQU 1\n.END",
+		"equ twice":       "N .EQU 1\nN .EQU 2\n.ORIG x3000\n.END",
+		"equ then set":    "N .EQU 1\nN .SET 2\n.ORIG x3000\n.END",
+		"equ label":       "N .EQU 1\n.ORIG x3000\nN RET\n.END",
+		"equ no label":    ".EQU 1\n.ORIG x3000\n.END",
+		"local backward":  ".ORIG x3000\nBR 1b\n1: RET\n.END",
+		"local forward":   ".ORIG x3000\n1: BR 1f\n.END",
+		"local reference": ".ORIG x3000\n1b RET\n.END",
+		"local constant":  "1: .EQU 1\n.ORIG x3000\n.END",
+		"char imm5":       ".ORIG x3000\nADD R0, R0, 'A'\n.END",
+		"origin range":    ".ORIG 0x10000\n.END",
+		"register R8":     ".ORIG x3000\nADD R8, R1, R1\n.END",
+		"overlap wide":    ".ORIG x3000\n.BLKW 16\n.END\n.ORIG x3002\n.END\n.ORIG x3004\n.FILL 1\n.END",
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := assemble(t, src)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestAssemble_ErrorList(t *testing.T) {
+	src := ".ORIG x3000\nLOOP ADD R1, R1, #100\nBR LOPO\n.FIL 3\nA .FILL 1\nA .FILL 2\nNOT R0\n.END"
+
+	_, err := assemble(t, src)
+	require.Error(t, err)
+
+	errs := err.(ErrorList)
+	var lines []int
+	var msgs []string
+	for _, e := range errs {
+		lines = append(lines, e.Pos.Line)
+		msgs = append(msgs, e.Msg)
+	}
+	assert.Equal(t, []int{2, 3, 4, 6, 7}, lines)
+	assert.Equal(t, "undefined label 'LOPO', did you mean 'LOOP'?", msgs[1])
+	assert.Equal(t, "unknown directive '.FIL', did you mean '.FILL'?", msgs[2])
+}
+
+func TestAssemble_PreprocessorErrors(t *testing.T) {
+	src := ".ORIG x3000\n.ENDIF\nADDD R0, R0, R0\n.MACRO R1\n.ENDM\n.END\n"
+
+	_, err := Assemble(strings.NewReader(src), WithFilename("a.asm"))
+	require.Error(t, err)
+
+	var errs ErrorList
+	require.True(t, errors.As(err, &errs))
+	var lines []int
+	for _, e := range errs {
+		lines = append(lines, e.Pos.Line)
+	}
+	assert.Equal(t, []int{2, 3, 4}, lines)
+}
+
+func TestAssemble_Example(t *testing.T) {
+	fp, err := os.Open("../../_examples/os.asm")
+	require.NoError(t, err)
+	defer func() { _ = fp.Close() }()
+
+	prog, err := parser.Parse(fp, parser.WithJMPT())
+	require.NoError(t, err)
+
+	img, err := AssembleProgram(prog)
+	require.NoError(t, err)
+
+	words := img.Segments[0].Words
+	assert.Equal(t, img.Symbols["BAD_TRAP"], words[0x00])
+	assert.Equal(t, img.Symbols["TRAP_GETC"], words[0x20])
+	assert.Equal(t, img.Symbols["BAD_INT"], words[0x100])
+	assert.Equal(t, uint16(0x0200), img.Symbols["OS_START"])
+	assert.Equal(t, bytecode.RTI(), words[img.Symbols["BAD_INT"]])
+}
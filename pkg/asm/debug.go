@@ -0,0 +1,119 @@
+package asm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// DebugInfo ties every word of an image to the source it was assembled
+// from, words are ordered by address
+type DebugInfo struct {
+	Words []DebugWord
+}
+
+// DebugWord describes a word of an image. Label is the closest label at or
+// before the word within its segment and Offset is the distance from it.
+type DebugWord struct {
+	Addr   uint16
+	File   string
+	Line   int
+	Column int
+	Label  string `json:",omitempty"`
+	Offset int    `json:",omitempty"`
+	// Code tells instructions from data
+	Code bool `json:",omitempty"`
+}
+
+// Symbol returns the address of a word relative to its label as LABEL or
+// LABEL+N, words before any label have no symbol
+func (w *DebugWord) Symbol() string {
+	if w.Label == "" || w.Offset == 0 {
+		return w.Label
+	}
+
+	return fmt.Sprintf("%s+%d", w.Label, w.Offset)
+}
+
+func (w *DebugWord) String() string {
+	pos := fmt.Sprintf("%s:%d:%d", w.File, w.Line, w.Column)
+	if sym := w.Symbol(); sym != "" {
+		return pos + " " + sym
+	}
+
+	return pos
+}
+
+// NewDebugInfo collects debug info from the source map of an assembled or
+// linked image
+func NewDebugInfo(img *Image) *DebugInfo {
+	var info DebugInfo
+	label, labelAddr := "", 0
+	seg, next := -1, -1
+	for _, e := range img.SourceMap {
+		// a label does not reach into another segment
+		if e.Segment != seg || int(e.Addr) != next {
+			label = ""
+		}
+		seg, next = e.Segment, int(e.Addr)+len(e.Words)
+		if len(e.Labels) != 0 {
+			label, labelAddr = e.Labels[0], int(e.Addr)
+		}
+
+		for i := range e.Words {
+			addr := int(e.Addr) + i
+			w := DebugWord{
+				Addr:   uint16(addr),
+				File:   e.Pos.Filename,
+				Line:   e.Pos.Line,
+				Column: e.Pos.Column,
+				Code:   e.Code,
+			}
+			if label != "" {
+				w.Label, w.Offset = label, addr-labelAddr
+			}
+			info.Words = append(info.Words, w)
+		}
+	}
+	info.sort()
+
+	return &info
+}
+
+func (d *DebugInfo) sort() {
+	sort.SliceStable(d.Words, func(i, j int) bool {
+		return d.Words[i].Addr < d.Words[j].Addr
+	})
+}
+
+// Lookup returns the description of the word at addr
+func (d *DebugInfo) Lookup(addr uint16) (*DebugWord, bool) {
+	i := sort.Search(len(d.Words), func(i int) bool {
+		return d.Words[i].Addr >= addr
+	})
+	if i == len(d.Words) || d.Words[i].Addr != addr {
+		return nil, false
+	}
+
+	return &d.Words[i], true
+}
+
+// WriteDebugInfo writes debug info as JSON, the .dbg file lc3 run --trace
+// reads
+func WriteDebugInfo(w io.Writer, d *DebugInfo) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(d)
+}
+
+func ReadDebugInfo(r io.Reader) (*DebugInfo, error) {
+	var d DebugInfo
+	if err := json.NewDecoder(r).Decode(&d); err != nil {
+		return nil, fmt.Errorf("malformed debug info file: %w", err)
+	}
+	d.sort()
+
+	return &d, nil
+}
@@ -0,0 +1,49 @@
+package asm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDebugInfo(t *testing.T) {
+	img, err := Assemble(strings.NewReader(`.ORIG x3000
+START   LD R0, 1f
+        HALT
+1:      .FILL #5
+        .END
+        .ORIG x4000
+        .FILL START
+DATA    .BLKW 2
+        .END
+`), WithFilename("test.asm"))
+	require.NoError(t, err)
+
+	info := NewDebugInfo(img)
+	assert.Equal(t, []DebugWord{
+		{Addr: 0x3000, File: "test.asm", Line: 2, Column: 1, Label: "START", Code: true},
+		{Addr: 0x3001, File: "test.asm", Line: 3, Column: 9, Label: "START", Offset: 1, Code: true},
+		{Addr: 0x3002, File: "test.asm", Line: 4, Column: 1, Label: "START", Offset: 2},
+		{Addr: 0x4000, File: "test.asm", Line: 7, Column: 9},
+		{Addr: 0x4001, File: "test.asm", Line: 8, Column: 1, Label: "DATA"},
+		{Addr: 0x4002, File: "test.asm", Line: 8, Column: 1, Label: "DATA", Offset: 1},
+	}, info.Words)
+
+	w, ok := info.Lookup(0x3001)
+	require.True(t, ok)
+	assert.Equal(t, "test.asm:3:9 START+1", w.String())
+	w, ok = info.Lookup(0x4000)
+	require.True(t, ok)
+	assert.Equal(t, "test.asm:7:9", w.String())
+	_, ok = info.Lookup(0x3003)
+	assert.False(t, ok)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteDebugInfo(&buf, info))
+	read, err := ReadDebugInfo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, info, read)
+}
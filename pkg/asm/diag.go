@@ -0,0 +1,128 @@
+package asm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/v2"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+// Errors flattens errors of the preprocessor, parser and assembler into a
+// single list ordered by file, in order of appearance, and position within
+// the file
+func Errors(errs ...error) []*Error {
+	var list []*Error
+	var add func(err error)
+	add = func(err error) {
+		switch e := err.(type) {
+		case nil:
+		case *Error:
+			list = append(list, e)
+		case ErrorList:
+			for _, err := range e {
+				add(err)
+			}
+		case parser.ErrorList:
+			for _, err := range e {
+				add(err)
+			}
+		case participle.Error:
+			list = append(list, &Error{Pos: e.Position(), Msg: e.Message()})
+		default:
+			list = append(list, &Error{Msg: err.Error()})
+		}
+	}
+	for _, err := range errs {
+		add(err)
+	}
+
+	files := map[string]int{}
+	for _, e := range list {
+		if _, ok := files[e.Pos.Filename]; !ok {
+			files[e.Pos.Filename] = len(files)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i].Pos, list[j].Pos
+		if a.Filename != b.Filename {
+			return files[a.Filename] < files[b.Filename]
+		}
+		return a.Offset < b.Offset
+	})
+
+	return list
+}
+
+// WriteErrors writes errors as "file:line:col: error: message" followed by
+// the source line and a caret under the column, sources maps file names to
+// their contents and lacking ones are written without snippets
+func WriteErrors(w io.Writer, errs []*Error, sources map[string][]byte) error {
+	lines := map[string][]string{}
+	for name, src := range sources {
+		lines[name] = strings.Split(string(src), "\n")
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, e := range errs {
+		if e.Pos.Line == 0 {
+			fmt.Fprintf(bw, "error: %s\n", e.Msg)
+			continue
+		}
+		fmt.Fprintf(bw, "%s: error: %s\n", e.Pos, e.Msg)
+
+		src := lines[e.Pos.Filename]
+		if e.Pos.Line > len(src) {
+			continue
+		}
+		text := strings.TrimRight(src[e.Pos.Line-1], "\r")
+		fmt.Fprintf(bw, "%5d | %s\n", e.Pos.Line, text)
+		fmt.Fprintf(bw, "      | %s^\n", caretIndent(text, e.Pos.Column))
+	}
+
+	return bw.Flush()
+}
+
+// caretIndent keeps tabs of the source line so the caret lines up with the
+// column whatever the tab width is, columns count runes
+func caretIndent(text string, column int) string {
+	var b strings.Builder
+	for i, r := range []rune(text) {
+		if i >= column-1 {
+			break
+		}
+		if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+
+	return b.String()
+}
+
+type jsonError struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+// WriteErrorsJSON writes errors as a JSON array of objects with file, line,
+// column and message fields
+func WriteErrorsJSON(w io.Writer, errs []*Error) error {
+	list := make([]jsonError, len(errs))
+	for i, e := range errs {
+		list[i] = jsonError{File: e.Pos.Filename, Line: e.Pos.Line, Column: e.Pos.Column, Message: e.Msg}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(list)
+}
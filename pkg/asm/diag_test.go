@@ -0,0 +1,64 @@
+package asm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+func TestErrors(t *testing.T) {
+	at := func(file string, offset int) lexer.Position {
+		return lexer.Position{Filename: file, Offset: offset, Line: 1, Column: 1}
+	}
+	parseErr := parser.ErrorList{participle.Errorf(at("a.asm", 20), "syntax")}
+	asmErr := ErrorList{errorf(at("b.asm", 0), "included"), errorf(at("a.asm", 5), "first")}
+
+	errs := Errors(parseErr, nil, asmErr)
+	require.Len(t, errs, 3)
+	assert.Equal(t, "first", errs[0].Msg)
+	assert.Equal(t, "syntax", errs[1].Msg)
+	assert.Equal(t, "included", errs[2].Msg)
+}
+
+func TestWriteErrors(t *testing.T) {
+	errs := []*Error{
+		errorf(lexer.Position{Filename: "a.asm", Line: 2, Column: 5}, "undefined label 'LOPO'"),
+		errorf(lexer.Position{Filename: "b.asm", Line: 1, Column: 1}, "no source"),
+		{Msg: "no position"},
+	}
+	sources := map[string][]byte{"a.asm": []byte(".ORIG x3000\n\tBR LOPO\n.END\n")}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteErrors(&buf, errs, sources))
+	assert.Equal(t, "a.asm:2:5: error: undefined label 'LOPO'\n"+
+		"    2 | \tBR LOPO\n"+
+		"      | \t   ^\n"+
+		"b.asm:1:1: error: no source\n"+
+		"error: no position\n", buf.String())
+}
+
+func TestWriteErrors_Multibyte(t *testing.T) {
+	errs := []*Error{errorf(lexer.Position{Filename: "a.asm", Line: 1, Column: 16}, "bad")}
+	sources := map[string][]byte{"a.asm": []byte(".STRINGZ \"é😀\", X\n")}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteErrors(&buf, errs, sources))
+	assert.Equal(t, "a.asm:1:16: error: bad\n"+
+		"    1 | .STRINGZ \"é😀\", X\n"+
+		"      | "+strings.Repeat(" ", 15)+"^\n", buf.String())
+}
+
+func TestWriteErrorsJSON(t *testing.T) {
+	errs := []*Error{errorf(lexer.Position{Filename: "a.asm", Line: 2, Column: 5}, "bad")}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteErrorsJSON(&buf, errs))
+	assert.JSONEq(t, `[{"file": "a.asm", "line": 2, "column": 5, "message": "bad"}]`, buf.String())
+}
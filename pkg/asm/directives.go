@@ -0,0 +1,180 @@
+package asm
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+// directive describes a data directive. .ORIG and .END delimit segments,
+// .EQU and .SET define constants, .GLOBAL and .EXTERNAL declare symbols,
+// these are handled by the assembler passes themselves.
+type directive struct {
+	// size returns the number of words the directive occupies, it is
+	// called by the first pass and validates the arguments
+	size func(a *assembler, d *parser.Directive) (int, error)
+	// emit produces the words in the second pass
+	emit func(a *assembler, d *parser.Directive) error
+}
+
+var directives = map[string]directive{
+	// .FILL value - a single word holding a number or a label address
+	".fill": {size: sizeFill, emit: emitFill},
+	// .BLKW count [, value] - count words, zeroed or holding value
+	".blkw": {size: sizeBlkw, emit: emitBlkw},
+	// .STRINGZ "text" - characters of text one per word and a NUL word
+	".stringz": {size: sizeStringz, emit: emitStringz},
+}
+
+func (a *assembler) origin(d *parser.Directive) (uint16, error) {
+	if len(d.Args) != 1 || d.Args[0].Expr == nil {
+		return 0, errorf(d.Pos, ".ORIG expects a single address")
+	}
+
+	n, err := a.number(d.Args[0].Pos, d.Args[0].Expr)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > int(^uint16(0)) {
+		return 0, errorf(d.Args[0].Pos, "address %d is out of memory range [x0000, xFFFF]", n)
+	}
+
+	return uint16(n), nil
+}
+
+func (a *assembler) directiveSize(d *parser.Directive) (int, error) {
+	dir, ok := directives[strings.ToLower(*d.Name)]
+	if !ok {
+		return 0, errorf(d.Pos, "unknown directive '%s'%s", *d.Name, didYouMean(suggestDirective(*d.Name)))
+	}
+
+	return dir.size(a, d)
+}
+
+// Directives returns names of the directives the assembler handles
+func Directives() []string {
+	names := []string{".ORIG", ".END", ".EQU", ".SET", ".GLOBAL", ".EXTERNAL", ".SECTION"}
+	for n := range directives {
+		names = append(names, strings.ToUpper(n))
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func suggestDirective(name string) string {
+	return parser.Suggest(name, Directives())
+}
+
+func (a *assembler) directive(d *parser.Directive) error {
+	return directives[strings.ToLower(*d.Name)].emit(a, d)
+}
+
+func sizeFill(_ *assembler, d *parser.Directive) (int, error) {
+	if len(d.Args) != 1 || d.Args[0].String != nil {
+		return 0, errorf(d.Pos, ".FILL expects a single number or label")
+	}
+
+	return 1, nil
+}
+
+func emitFill(a *assembler, d *parser.Directive) error {
+	v, err := a.word(d.Args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.relocate(d.Args[0].Pos, a.here(), RelocAbs16, v); err != nil {
+		return err
+	}
+	a.emit(uint16(v.v))
+
+	return nil
+}
+
+func sizeBlkw(a *assembler, d *parser.Directive) (int, error) {
+	if len(d.Args) < 1 || len(d.Args) > 2 || d.Args[0].Expr == nil {
+		return 0, errorf(d.Pos, ".BLKW expects a non-negative number of words and an optional value")
+	}
+	if len(d.Args) == 2 && d.Args[1].String != nil {
+		return 0, errorf(d.Args[1].Pos, ".BLKW value must be a number or label")
+	}
+
+	return a.blkwCount(d)
+}
+
+func emitBlkw(a *assembler, d *parser.Directive) error {
+	var v value
+	if len(d.Args) == 2 {
+		var err error
+		if v, err = a.word(d.Args[1]); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.blkwCount(d)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := a.relocate(d.Args[len(d.Args)-1].Pos, a.here(), RelocAbs16, v); err != nil {
+			return err
+		}
+		a.emit(uint16(v.v))
+	}
+
+	return nil
+}
+
+func (a *assembler) blkwCount(d *parser.Directive) (int, error) {
+	n, err := a.number(d.Args[0].Pos, d.Args[0].Expr)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, errorf(d.Args[0].Pos, ".BLKW expects a non-negative number of words, got %d", n)
+	}
+
+	return n, nil
+}
+
+func sizeStringz(_ *assembler, d *parser.Directive) (int, error) {
+	if len(d.Args) != 1 || d.Args[0].String == nil {
+		return 0, errorf(d.Pos, ".STRINGZ expects a single string")
+	}
+
+	return len(*d.Args[0].String) + 1, nil
+}
+
+func emitStringz(a *assembler, d *parser.Directive) error {
+	for _, c := range []byte(*d.Args[0].String) {
+		a.emit(uint16(c))
+	}
+	a.emit(0)
+
+	return nil
+}
+
+// word resolves a number or address expression into a single word value
+func (a *assembler) word(arg *parser.DirectiveArg) (value, error) {
+	return a.wordValue(arg.Pos, arg.Expr)
+}
+
+// wordValue evaluates an expression that fits into a word, the caller
+// records the relocation of an address
+func (a *assembler) wordValue(pos lexer.Position, e *parser.Expr) (value, error) {
+	v, err := a.eval(e)
+	if err != nil {
+		return value{}, err
+	}
+	if v.rel != 0 && v.rel != 1 {
+		return value{}, errorf(pos, "expression must be an address or a number")
+	}
+	if v.v < -0x8000 || v.v > 0xFFFF {
+		return value{}, errorf(pos, "value %d does not fit into a word [-32768, 65535]", v.v)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,143 @@
+package asm
+
+import (
+	"github.com/alecthomas/participle/v2/lexer"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+// value is the result of an expression. rel counts label addresses the
+// value is made of: it is 1 for an address such as TABLE+3 and 0 for a plain
+// number such as END-START. ext names the external symbol an address such as
+// PRINT+1 is relative to, v holds the offset then. seg is the index of the
+// segment an address points into.
+type value struct {
+	v   int
+	rel int
+	ext string
+	seg int
+}
+
+func (a *assembler) eval(e *parser.Expr) (value, error) {
+	lhs, err := a.evalTerm(e.Left)
+	if err != nil {
+		return value{}, err
+	}
+
+	for _, op := range e.Right {
+		rhs, err := a.evalTerm(op.Term)
+		if err != nil {
+			return value{}, err
+		}
+		if lhs.ext != "" && rhs.rel != 0 || rhs.ext != "" && (lhs.rel != 0 || op.Op == "-") {
+			return value{}, errorf(op.Term.Pos, "external symbol addresses can only be offset by a number")
+		}
+		if lhs.rel != 0 && rhs.rel != 0 && lhs.seg != rhs.seg && (a.inSection(lhs.seg) || a.inSection(rhs.seg)) {
+			return value{}, errorf(op.Term.Pos, "addresses of different sections can not be combined")
+		}
+		seg := lhs.seg
+		if lhs.rel == 0 {
+			seg = rhs.seg
+		}
+		if op.Op == "-" {
+			rhs = value{v: -rhs.v, rel: -rhs.rel}
+		}
+		lhs = value{v: lhs.v + rhs.v, rel: lhs.rel + rhs.rel, ext: lhs.ext + rhs.ext, seg: seg}
+	}
+
+	return lhs, nil
+}
+
+func (a *assembler) evalTerm(t *parser.Term) (value, error) {
+	lhs, err := a.evalUnary(t.Left)
+	if err != nil {
+		return value{}, err
+	}
+
+	for _, op := range t.Right {
+		rhs, err := a.evalUnary(op.Unary)
+		if err != nil {
+			return value{}, err
+		}
+		if lhs.rel != 0 || rhs.rel != 0 {
+			return value{}, errorf(t.Pos, "label addresses can only be added or subtracted")
+		}
+		if op.Op != "*" && rhs.v == 0 {
+			return value{}, errorf(op.Unary.Pos, "division by zero")
+		}
+		switch op.Op {
+		case "*":
+			lhs.v *= rhs.v
+		case "/":
+			lhs.v /= rhs.v
+		case "%":
+			lhs.v %= rhs.v
+		}
+	}
+
+	return lhs, nil
+}
+
+func (a *assembler) evalUnary(u *parser.Unary) (value, error) {
+	if u.Operand != nil {
+		return a.evalOperand(u.Operand)
+	}
+
+	v, err := a.evalUnary(u.Unary)
+	if err != nil {
+		return value{}, err
+	}
+	if v.rel != 0 {
+		return value{}, errorf(u.Pos, "label addresses can only be added or subtracted")
+	}
+	if *u.Op == "~" {
+		return value{v: ^v.v}, nil
+	}
+
+	return value{v: -v.v}, nil
+}
+
+func (a *assembler) evalOperand(o *parser.Operand) (value, error) {
+	switch {
+	case o.Number != nil:
+		return value{v: int(*o.Number)}, nil
+	case o.Sub != nil:
+		return a.eval(o.Sub)
+	}
+
+	if c, ok := a.consts[*o.Label]; ok {
+		return c, nil
+	}
+
+	if _, ok := a.externs[*o.Label]; ok && !a.defined(*o.Label) {
+		return value{rel: 1, ext: *o.Label}, nil
+	}
+
+	if n, forward, ok := parser.LocalRef(*o.Label); ok {
+		d, err := a.localLookup(o.Pos, *o.Label, n, forward)
+		if err != nil {
+			return value{}, err
+		}
+		return value{v: int(d.addr), rel: 1, seg: d.seg}, nil
+	}
+
+	addr, err := a.lookup(o.Pos, *o.Label)
+	if err != nil {
+		return value{}, err
+	}
+
+	return value{v: int(addr), rel: 1, seg: a.symSegs[*o.Label]}, nil
+}
+
+// number evaluates an expression that must not depend on label addresses
+func (a *assembler) number(pos lexer.Position, e *parser.Expr) (int, error) {
+	v, err := a.eval(e)
+	if err != nil {
+		return 0, err
+	}
+	if v.rel != 0 {
+		return 0, errorf(pos, "constant expression expected")
+	}
+
+	return v.v, nil
+}
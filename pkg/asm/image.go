@@ -0,0 +1,277 @@
+package asm
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type Format string
+
+const (
+	// FormatObj is the standard LC-3 object file: a big-endian origin word
+	// followed by big-endian code words, it holds a single segment
+	FormatObj Format = "obj"
+	// FormatBin is a raw memory image starting from address 0
+	FormatBin Format = "bin"
+	// FormatSeg holds several segments each loaded at its own origin, other
+	// LC-3 tools do not read it, see WriteSeg
+	FormatSeg Format = "seg"
+)
+
+// ParseFormat checks the name of an image format
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatObj, FormatBin, FormatSeg:
+		return f, nil
+	}
+
+	return "", fmt.Errorf("unknown image format '%s'", s)
+}
+
+// Write writes the image in its Format
+func (img *Image) Write(w io.Writer) error {
+	return Write(w, img, img.Format)
+}
+
+func Write(w io.Writer, img *Image, f Format) error {
+	switch f {
+	case FormatObj:
+		return WriteObj(w, img)
+	case FormatBin:
+		return WriteBin(w, img)
+	case FormatSeg:
+		return WriteSeg(w, img)
+	}
+
+	return fmt.Errorf("unknown image format '%s'", f)
+}
+
+// Read reads an image written in the given format, bin images are cut at
+// origin as they do not record one
+func Read(r io.Reader, f Format, origin uint16) (*Image, error) {
+	switch f {
+	case FormatObj:
+		return ReadObj(r)
+	case FormatBin:
+		return ReadBin(r, origin)
+	case FormatSeg:
+		return ReadSeg(r)
+	}
+
+	return nil, fmt.Errorf("unknown image format '%s'", f)
+}
+
+// nonEmpty returns the segments holding words
+func nonEmpty(segs []Segment) []Segment {
+	var words []Segment
+	for _, seg := range segs {
+		if len(seg.Words) != 0 {
+			words = append(words, seg)
+		}
+	}
+
+	return words
+}
+
+// WriteObj writes img as a standard LC-3 object file, the origin followed by
+// the words. An object file holds a single segment, images of several are
+// written with WriteBin or WriteSeg.
+func WriteObj(w io.Writer, img *Image) error {
+	segs := nonEmpty(img.Segments)
+	switch len(segs) {
+	case 0:
+		var origin uint16
+		if len(img.Segments) != 0 {
+			origin = img.Segments[0].Origin
+		}
+		return binary.Write(w, binary.BigEndian, origin)
+	case 1:
+	default:
+		return fmt.Errorf("an obj file holds a single segment but the image has %d, write it as bin or seg", len(segs))
+	}
+
+	if err := binary.Write(w, binary.BigEndian, segs[0].Origin); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.BigEndian, segs[0].Words)
+}
+
+// ReadObj reads a standard LC-3 object file written by WriteObj or by other
+// LC-3 tools
+func ReadObj(r io.Reader) (*Image, error) {
+	words, err := readWords(r, binary.BigEndian, FormatObj)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, errors.New("malformed obj file: missing origin")
+	}
+
+	origin := words[0]
+	if err := checkObjSegment(origin, len(words)-1); err != nil {
+		return nil, err
+	}
+
+	return &Image{
+		Segments: []Segment{{Origin: origin, Words: words[1:]}},
+	}, nil
+}
+
+// WriteSeg writes img as blocks of an origin, a number of words and the
+// words, all big-endian, so that a loader places every segment at its own
+// origin and memory between them stays untouched
+func WriteSeg(w io.Writer, img *Image) error {
+	for _, seg := range nonEmpty(img.Segments) {
+		header := []uint16{seg.Origin, uint16(len(seg.Words))}
+		if err := binary.Write(w, binary.BigEndian, header); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, seg.Words); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadSeg reads an image written by WriteSeg
+func ReadSeg(r io.Reader) (*Image, error) {
+	words, err := readWords(r, binary.BigEndian, FormatSeg)
+	if err != nil {
+		return nil, err
+	}
+
+	var img Image
+	for len(words) != 0 {
+		if len(words) < 2 {
+			return nil, errors.New("malformed seg file: truncated segment header")
+		}
+		origin, n := words[0], int(words[1])
+		words = words[2:]
+		if n > len(words) {
+			return nil, fmt.Errorf("malformed seg file: segment at x%04X holds %d words, %d left in the file",
+				origin, n, len(words))
+		}
+		if int(origin)+n > int(^uint16(0))+1 {
+			return nil, fmt.Errorf("malformed seg file: %d words at x%04X overflow memory", n, origin)
+		}
+		img.Segments = append(img.Segments, Segment{Origin: origin, Words: words[:n]})
+		words = words[n:]
+	}
+
+	return &img, nil
+}
+
+// readWords reads an image file of 16-bit words
+func readWords(r io.Reader, order binary.ByteOrder, f Format) ([]uint16, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("malformed %s file: odd size", f)
+	}
+
+	words := make([]uint16, len(data)/2)
+	for i := range words {
+		words[i] = order.Uint16(data[i*2:])
+	}
+
+	return words, nil
+}
+
+func checkObjSegment(origin uint16, n int) error {
+	if int(origin)+n > int(^uint16(0))+1 {
+		return fmt.Errorf("malformed obj file: %d words at x%04X overflow memory", n, origin)
+	}
+
+	return nil
+}
+
+// WriteBin writes a raw memory image starting from address 0, the layout
+// machine.NewMemoryWriter loads, gaps between segments are zero-filled
+func WriteBin(w io.Writer, img *Image) error {
+	origin, words := flatten(img.Segments)
+	mem := append(make([]uint16, origin), words...)
+
+	return binary.Write(w, binary.LittleEndian, mem)
+}
+
+// ReadBin reads a raw memory image written by WriteBin, the image does not
+// record where the program starts so its segment is cut at the given origin
+func ReadBin(r io.Reader, origin uint16) (*Image, error) {
+	words, err := readWords(r, binary.LittleEndian, FormatBin)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) > int(^uint16(0))+1 {
+		return nil, errors.New("malformed bin file: larger than memory")
+	}
+	if int(origin) >= len(words) {
+		return nil, fmt.Errorf("origin x%04X is past the end of the %d-word bin image", origin, len(words))
+	}
+
+	return &Image{
+		Segments: []Segment{{Origin: origin, Words: words[origin:]}},
+	}, nil
+}
+
+func flatten(segs []Segment) (uint16, []uint16) {
+	start, end := int(^uint16(0))+1, 0
+	for _, seg := range segs {
+		if len(seg.Words) == 0 {
+			continue
+		}
+		if int(seg.Origin) < start {
+			start = int(seg.Origin)
+		}
+		if e := int(seg.Origin) + len(seg.Words); e > end {
+			end = e
+		}
+	}
+	if end == 0 {
+		if len(segs) == 0 {
+			return 0, nil
+		}
+		return segs[0].Origin, nil
+	}
+
+	words := make([]uint16, end-start)
+	for _, seg := range segs {
+		if len(seg.Words) != 0 {
+			copy(words[int(seg.Origin)-start:], seg.Words)
+		}
+	}
+
+	return uint16(start), words
+}
+
+// WriteObject writes a relocatable module assembled WithRelocatable as JSON
+// holding segments, symbols, the source map, globals and relocations, the
+// input of the linker
+func WriteObject(w io.Writer, img *Image) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(img)
+}
+
+func ReadObject(r io.Reader) (*Image, error) {
+	var img Image
+	if err := json.NewDecoder(r).Decode(&img); err != nil {
+		return nil, fmt.Errorf("malformed object file: %w", err)
+	}
+	for _, rel := range img.Relocations {
+		switch rel.Kind {
+		case RelocAbs16, RelocPC9, RelocPC11:
+		default:
+			return nil, fmt.Errorf("malformed object file: unknown relocation kind '%s'", rel.Kind)
+		}
+	}
+
+	return &img, nil
+}
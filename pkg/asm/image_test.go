@@ -0,0 +1,152 @@
+package asm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/machine"
+)
+
+func TestWriteBin(t *testing.T) {
+	var buf bytes.Buffer
+
+	img := &Image{Segments: []Segment{{Origin: 2, Words: []uint16{0x1234, 0xABCD}}}}
+	assert.NoError(t, WriteBin(&buf, img))
+	assert.Equal(t, []byte{0, 0, 0, 0, 0x34, 0x12, 0xCD, 0xAB}, buf.Bytes())
+
+	var m machine.Memory
+	_, err := machine.NewMemoryWriter(&m).Write(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0x1234), m.ReadWord(2))
+	assert.Equal(t, uint16(0xABCD), m.ReadWord(3))
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range []Format{FormatObj, FormatBin, FormatSeg} {
+		got, err := ParseFormat(string(f))
+		assert.NoError(t, err)
+		assert.Equal(t, f, got)
+	}
+
+	_, err := ParseFormat("xyz")
+	assert.EqualError(t, err, "unknown image format 'xyz'")
+}
+
+func TestWriteObj(t *testing.T) {
+	var buf bytes.Buffer
+
+	img := &Image{Segments: []Segment{{Origin: 0x3000, Words: []uint16{0x1234, 0xABCD}}}}
+	assert.NoError(t, WriteObj(&buf, img))
+	assert.Equal(t, []byte{0x30, 0x00, 0x12, 0x34, 0xAB, 0xCD}, buf.Bytes())
+}
+
+func TestWriteObj_Segments(t *testing.T) {
+	var buf bytes.Buffer
+
+	img := &Image{Segments: []Segment{
+		{Origin: 0x3004, Words: []uint16{0xABCD}},
+		{Origin: 0x0000, Words: nil},
+	}}
+	assert.NoError(t, WriteObj(&buf, img))
+	assert.Equal(t, []byte{0x30, 0x04, 0xAB, 0xCD}, buf.Bytes())
+
+	// other LC-3 tools read an obj file as a single block
+	img.Segments = append(img.Segments, Segment{Origin: 0x3000, Words: []uint16{0x1234}})
+	assert.EqualError(t, WriteObj(&buf, img),
+		"an obj file holds a single segment but the image has 2, write it as bin or seg")
+}
+
+func TestWriteSeg(t *testing.T) {
+	var buf bytes.Buffer
+
+	img := &Image{Segments: []Segment{
+		{Origin: 0x3004, Words: []uint16{0xABCD}},
+		{Origin: 0x3000, Words: []uint16{0x1234}},
+		{Origin: 0x0000, Words: nil},
+	}}
+	assert.NoError(t, WriteSeg(&buf, img))
+	assert.Equal(t, []byte{
+		0x30, 0x04, 0x00, 0x01, 0xAB, 0xCD,
+		0x30, 0x00, 0x00, 0x01, 0x12, 0x34,
+	}, buf.Bytes())
+
+	read, err := ReadSeg(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, img.Segments[:2], read.Segments)
+}
+
+func TestReadSeg_LeavesGaps(t *testing.T) {
+	var buf bytes.Buffer
+	img := &Image{Segments: []Segment{
+		{Origin: 0x3000, Words: []uint16{0x1234}},
+		{Origin: 0xC000, Words: []uint16{0xABCD}},
+	}}
+	require.NoError(t, WriteSeg(&buf, img))
+	assert.Equal(t, 12, buf.Len())
+
+	read, err := ReadSeg(&buf)
+	require.NoError(t, err)
+
+	var m machine.Memory
+	m.WriteWord(0x3001, 0x5555)
+	m.WriteWord(0xBFFF, 0x5555)
+	for _, seg := range read.Segments {
+		m.WriteSegment(seg.Origin, seg.Words)
+	}
+	assert.Equal(t, uint16(0x1234), m.ReadWord(0x3000))
+	assert.Equal(t, uint16(0x5555), m.ReadWord(0x3001))
+	assert.Equal(t, uint16(0x5555), m.ReadWord(0xBFFF))
+	assert.Equal(t, uint16(0xABCD), m.ReadWord(0xC000))
+}
+
+func TestReadSeg(t *testing.T) {
+	_, err := ReadSeg(bytes.NewReader([]byte{0x30, 0}))
+	assert.EqualError(t, err, "malformed seg file: truncated segment header")
+
+	// a segment claiming more words than the file holds
+	_, err = ReadSeg(bytes.NewReader([]byte{0x30, 0, 0, 5, 0, 1}))
+	assert.Error(t, err)
+
+	_, err = ReadSeg(bytes.NewReader([]byte{0xFF, 0xFF, 0, 2, 0, 1, 0, 2}))
+	assert.Error(t, err)
+}
+
+func TestReadObj(t *testing.T) {
+	img, err := ReadObj(bytes.NewReader([]byte{0x30, 0x00, 0x12, 0x34, 0xAB, 0xCD}))
+	assert.NoError(t, err)
+	assert.Equal(t, []Segment{{Origin: 0x3000, Words: []uint16{0x1234, 0xABCD}}}, img.Segments)
+
+	_, err = ReadObj(bytes.NewReader([]byte{0x30}))
+	assert.Error(t, err)
+
+	_, err = ReadObj(bytes.NewReader(nil))
+	assert.Error(t, err)
+
+	_, err = ReadObj(bytes.NewReader([]byte{0xFF, 0xFF, 0, 1, 0, 2}))
+	assert.Error(t, err)
+
+	img, err = ReadObj(bytes.NewReader([]byte{0xFF, 0xFF, 0, 1}))
+	assert.NoError(t, err)
+	assert.Equal(t, []Segment{{Origin: 0xFFFF, Words: []uint16{1}}}, img.Segments)
+}
+
+func TestReadBin(t *testing.T) {
+	bin := []byte{0, 0, 0, 0, 0x34, 0x12, 0, 0, 0xCD, 0xAB, 0, 0}
+	img, err := ReadBin(bytes.NewReader(bin), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []Segment{{Origin: 2, Words: []uint16{0x1234, 0, 0xABCD, 0}}}, img.Segments)
+
+	// zeros at the origin are kept, the origin is not guessed
+	img, err = ReadBin(bytes.NewReader(bin), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []Segment{{Origin: 1, Words: []uint16{0, 0x1234, 0, 0xABCD, 0}}}, img.Segments)
+
+	_, err = ReadBin(bytes.NewReader(bin), 6)
+	assert.EqualError(t, err, "origin x0006 is past the end of the 6-word bin image")
+
+	_, err = ReadBin(bytes.NewReader([]byte{0, 0, 1}), 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteListing writes an assembler listing, one line per source line. Each
+// line shows the address, hexadecimal and binary encodings, the line number
+// and the source text. Statements emitting several words, as well as macro
+// expansions, continue on extra lines holding just addresses and encodings.
+// Code from files other than filename, pulled in with .INCLUDE, continues
+// the source line assembled before it.
+func WriteListing(w io.Writer, filename string, src []byte, img *Image) error {
+	entries := map[int][]SourceEntry{}
+	lineNo := 0
+	for _, e := range img.SourceMap {
+		if e.Pos.Filename == filename {
+			lineNo = e.Pos.Line
+		} else {
+			e.Pos.Line = lineNo
+		}
+		entries[lineNo] = append(entries[lineNo], e)
+	}
+
+	bw := bufio.NewWriter(w)
+	writeContinued(bw, entries[0])
+
+	lines := strings.Split(strings.TrimSuffix(string(src), "\n"), "\n")
+	for i, text := range lines {
+		lineNo := i + 1
+		text = strings.TrimRight(text, "\r")
+
+		// the source text goes along with the first word of the line
+		es := entries[lineNo]
+		head := -1
+		for k, e := range es {
+			if e.Pos.Filename == filename && len(e.Words) != 0 {
+				head = k
+				break
+			}
+		}
+
+		switch {
+		case head != -1:
+			e := es[head]
+			_, _ = fmt.Fprintf(bw, "(%04X) %04X  %016b (%4d) %s\n", e.Addr, e.Words[0], e.Words[0], lineNo, text)
+			es = append([]SourceEntry{{Addr: e.Addr + 1, Words: e.Words[1:]}}, es[head+1:]...)
+		case len(es) != 0:
+			_, _ = fmt.Fprintf(bw, "(%04X) %-22s (%4d) %s\n", es[0].Addr, "", lineNo, text)
+		default:
+			_, _ = fmt.Fprintf(bw, "%-29s (%4d) %s\n", "", lineNo, text)
+		}
+		writeContinued(bw, es)
+	}
+
+	return bw.Flush()
+}
+
+// writeContinued writes words of entries on lines without source text
+func writeContinued(w io.Writer, entries []SourceEntry) {
+	for _, e := range entries {
+		for j, word := range e.Words {
+			_, _ = fmt.Fprintf(w, "(%04X) %04X  %016b\n", e.Addr+uint16(j), word, word)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package asm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+func TestWriteListing(t *testing.T) {
+	src := `; prints a letter
+        .ORIG x3000
+LOOP    LD R0, CHAR     ; load it
+        OUT
+CHAR    .STRINGZ "A"
+        .END
+`
+	img, err := assemble(t, src)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteListing(&buf, "", []byte(src), img))
+	assert.Equal(t, `                              (   1) ; prints a letter
+(3000)                        (   2)         .ORIG x3000
+(3000) 2001  0010000000000001 (   3) LOOP    LD R0, CHAR     ; load it
+(3001) F021  1111000000100001 (   4)         OUT
+(3002) 0041  0000000001000001 (   5) CHAR    .STRINGZ "A"
+(3003) 0000  0000000000000000
+(3004)                        (   6)         .END
+`, buf.String())
+}
+
+func TestWriteListing_Included(t *testing.T) {
+	src := `        .ORIG x3000
+        HALT
+.INCLUDE "lib.asm"
+        .END
+`
+	top := parser.SplitLines("main.asm", []byte(src))
+	lib := parser.SplitLines("lib.asm", []byte("ZERO    AND R0, R0, #0\n        RET\n"))
+	lines := append(append(top[:2:2], lib...), top[3])
+
+	prog, err := parser.ParseLines(lines)
+	require.NoError(t, err)
+	img, err := AssembleProgram(prog)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteListing(&buf, "main.asm", []byte(src), img))
+	assert.Equal(t, `(3000)                        (   1)         .ORIG x3000
+(3000) F025  1111000000100101 (   2)         HALT
+(3001) 5020  0101000000100000
+(3002) C1C0  1100000111000000
+                              (   3) .INCLUDE "lib.asm"
+(3003)                        (   4)         .END
+`, buf.String())
+}
@@ -0,0 +1,316 @@
+package asm
+
+import (
+	"strings"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/bytecode"
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+type opEncoder func(a *assembler, op *parser.Op) (uint16, error)
+
+var opEncoders = map[string]opEncoder{
+	"add":  encodeALU(bytecode.AddReg, bytecode.AddImm),
+	"and":  encodeALU(bytecode.AndReg, bytecode.AndImm),
+	"jmp":  encodeBaseReg(bytecode.JMP),
+	"jmpt": encodeBaseReg(bytecode.JMPT),
+	"jsrr": encodeBaseReg(bytecode.JSRR),
+	"jsr":  encodeJSR,
+	"ld":   encodeRegOffset9(bytecode.LD),
+	"ldi":  encodeRegOffset9(bytecode.LDI),
+	"lea":  encodeRegOffset9(bytecode.LEA),
+	"st":   encodeRegOffset9(bytecode.ST),
+	"sti":  encodeRegOffset9(bytecode.STI),
+	"ldr":  encodeRegRegOffset6(bytecode.LDR),
+	"str":  encodeRegRegOffset6(bytecode.STR),
+	"not":  encodeNot,
+	"ret":  encodeNoArgs(bytecode.RET),
+	"rti":  encodeNoArgs(bytecode.RTI),
+	"trap": encodeTrap,
+}
+
+var nzpFlags = map[rune]byte{'n': 0b100, 'z': 0b010, 'p': 0b001}
+
+var trapAliases = map[string]uint16{
+	"getc":  bytecode.Trap(0x20),
+	"out":   bytecode.Trap(0x21),
+	"puts":  bytecode.Trap(0x22),
+	"in":    bytecode.Trap(0x23),
+	"putsp": bytecode.Trap(0x24),
+	"halt":  bytecode.Trap(0x25),
+}
+
+func (a *assembler) op(op *parser.Op) error {
+	name := strings.ToLower(*op.OpCode)
+	if p, ok := a.pseudoOp(name); ok {
+		return p.emit(a, op)
+	}
+	if a.relaxed[a.stmt] {
+		return a.emitRelaxed(name, op)
+	}
+
+	enc, ok := opEncoders[name]
+	if !ok {
+		if !strings.HasPrefix(name, "b") {
+			return errorf(op.Pos, "unknown operation '%s'", *op.OpCode)
+		}
+		enc = encodeBR(name)
+	}
+
+	word, err := enc(a, op)
+	if err != nil {
+		return err
+	}
+	a.emit(word)
+
+	return nil
+}
+
+func (a *assembler) opSize(op *parser.Op) (int, error) {
+	if p, ok := a.pseudoOp(strings.ToLower(*op.OpCode)); ok {
+		return p.size(a, op)
+	}
+	if a.relaxed[a.stmt] {
+		a.pool.size++
+		return relaxedSize(strings.ToLower(*op.OpCode)), nil
+	}
+
+	return 1, nil
+}
+
+func encodeALU(
+	reg func(bytecode.Register, bytecode.Register, bytecode.Register) uint16,
+	imm func(bytecode.Register, bytecode.Register, int16) uint16,
+) opEncoder {
+	return func(a *assembler, op *parser.Op) (uint16, error) {
+		if err := checkArgs(op, 3); err != nil {
+			return 0, err
+		}
+		dr, err := register(op.Args[0])
+		if err != nil {
+			return 0, err
+		}
+		sr1, err := register(op.Args[1])
+		if err != nil {
+			return 0, err
+		}
+		if op.Args[2].Register != nil {
+			sr2, err := register(op.Args[2])
+			if err != nil {
+				return 0, err
+			}
+			return reg(dr, sr1, sr2), nil
+		}
+		imm5, err := a.immediate(op.Args[2], 5)
+		if err != nil {
+			return 0, err
+		}
+		return imm(dr, sr1, imm5), nil
+	}
+}
+
+// branchFlags returns condition flags of a branch, BR alone branches always
+func branchFlags(name string) byte {
+	flags := strings.TrimPrefix(strings.TrimPrefix(name, "b"), "r")
+
+	var nzp byte
+	for _, f := range flags {
+		nzp |= nzpFlags[f]
+	}
+	if nzp == 0 {
+		nzp = 0b111
+	}
+
+	return nzp
+}
+
+func encodeBR(name string) opEncoder {
+	nzp := branchFlags(name)
+
+	return func(a *assembler, op *parser.Op) (uint16, error) {
+		if err := checkArgs(op, 1); err != nil {
+			return 0, err
+		}
+		offset9, err := a.pcOffset(op.Args[0], 9)
+		if err != nil {
+			return 0, err
+		}
+		return bytecode.BRx(nzp, offset9), nil
+	}
+}
+
+func encodeBaseReg(enc func(bytecode.Register) uint16) opEncoder {
+	return func(a *assembler, op *parser.Op) (uint16, error) {
+		if err := checkArgs(op, 1); err != nil {
+			return 0, err
+		}
+		br, err := register(op.Args[0])
+		if err != nil {
+			return 0, err
+		}
+		return enc(br), nil
+	}
+}
+
+func encodeJSR(a *assembler, op *parser.Op) (uint16, error) {
+	if err := checkArgs(op, 1); err != nil {
+		return 0, err
+	}
+	offset11, err := a.pcOffset(op.Args[0], 11)
+	if err != nil {
+		return 0, err
+	}
+	return bytecode.JSR(offset11), nil
+}
+
+func encodeRegOffset9(enc func(bytecode.Register, int16) uint16) opEncoder {
+	return func(a *assembler, op *parser.Op) (uint16, error) {
+		if err := checkArgs(op, 2); err != nil {
+			return 0, err
+		}
+		r, err := register(op.Args[0])
+		if err != nil {
+			return 0, err
+		}
+		offset9, err := a.pcOffset(op.Args[1], 9)
+		if err != nil {
+			return 0, err
+		}
+		return enc(r, offset9), nil
+	}
+}
+
+func encodeRegRegOffset6(enc func(bytecode.Register, bytecode.Register, int16) uint16) opEncoder {
+	return func(a *assembler, op *parser.Op) (uint16, error) {
+		if err := checkArgs(op, 3); err != nil {
+			return 0, err
+		}
+		r, err := register(op.Args[0])
+		if err != nil {
+			return 0, err
+		}
+		br, err := register(op.Args[1])
+		if err != nil {
+			return 0, err
+		}
+		offset6, err := a.immediate(op.Args[2], 6)
+		if err != nil {
+			return 0, err
+		}
+		return enc(r, br, offset6), nil
+	}
+}
+
+func encodeNot(a *assembler, op *parser.Op) (uint16, error) {
+	if err := checkArgs(op, 2); err != nil {
+		return 0, err
+	}
+	dr, err := register(op.Args[0])
+	if err != nil {
+		return 0, err
+	}
+	sr, err := register(op.Args[1])
+	if err != nil {
+		return 0, err
+	}
+	return bytecode.Not(dr, sr), nil
+}
+
+func encodeNoArgs(enc func() uint16) opEncoder {
+	return func(a *assembler, op *parser.Op) (uint16, error) {
+		if err := checkArgs(op, 0); err != nil {
+			return 0, err
+		}
+		return enc(), nil
+	}
+}
+
+func encodeTrap(a *assembler, op *parser.Op) (uint16, error) {
+	if err := checkArgs(op, 1); err != nil {
+		return 0, err
+	}
+	arg := op.Args[0]
+	if arg.Expr == nil {
+		return 0, errorf(arg.Pos, "trap vector must be a number")
+	}
+	vec, err := a.number(arg.Pos, arg.Expr)
+	if err != nil {
+		return 0, err
+	}
+	if vec < 0 || vec > 0xFF {
+		return 0, errorf(arg.Pos, "trap vector x%X is out of range [x00, xFF]", vec)
+	}
+	return bytecode.Trap(uint8(vec)), nil
+}
+
+func checkArgs(op *parser.Op, n int) error {
+	if len(op.Args) != n {
+		return errorf(op.Pos, "%s expects %d operand(s), got %d", strings.ToUpper(*op.OpCode), n, len(op.Args))
+	}
+	return nil
+}
+
+func register(arg *parser.OpArgs) (bytecode.Register, error) {
+	if arg.Register == nil || *arg.Register > bytecode.R7 {
+		return 0, errorf(arg.Pos, "register R0-R7 expected")
+	}
+	return *arg.Register, nil
+}
+
+func (a *assembler) immediate(arg *parser.OpArgs, bits int) (int16, error) {
+	if arg.Expr == nil {
+		return 0, errorf(arg.Pos, "immediate value expected")
+	}
+	v, err := a.number(arg.Pos, arg.Expr)
+	if err != nil {
+		return 0, err
+	}
+	return checkSigned(arg, v, bits)
+}
+
+// pcOffset resolves an address operand into an offset relative to the
+// incremented PC, constant operands are taken as offsets as they are
+func (a *assembler) pcOffset(arg *parser.OpArgs, bits int) (int16, error) {
+	if arg.Expr == nil {
+		return 0, errorf(arg.Pos, "label or offset expected")
+	}
+	v, err := a.eval(arg.Expr)
+	if err != nil {
+		return 0, err
+	}
+
+	switch v.rel {
+	case 0:
+		return checkSigned(arg, v.v, bits)
+	case 1:
+		// targets outside of the module or in another section are known
+		// to the linker only
+		if v.ext != "" || a.crossSection(v) {
+			kind := RelocPC9
+			if bits == 11 {
+				kind = RelocPC11
+			}
+			return 0, a.relocate(arg.Pos, a.pc, kind, v)
+		}
+		offset := v.v - int(a.pc+1)
+		if lo, hi := signedRange(bits); offset < lo || offset > hi {
+			return 0, errorf(arg.Pos, "target x%04X is %d words away from PC x%04X, out of %d-bit offset range [%d, %d]",
+				uint16(v.v), offset, a.pc+1, bits, lo, hi)
+		}
+		return int16(offset), nil
+	}
+
+	return 0, errorf(arg.Pos, "expression must be an address or an offset")
+}
+
+func checkSigned(arg *parser.OpArgs, v int, bits int) (int16, error) {
+	lo, hi := signedRange(bits)
+	if v < lo || v > hi {
+		return 0, errorf(arg.Pos, "value %d does not fit into %d bits [%d, %d]", v, bits, lo, hi)
+	}
+	return int16(v), nil
+}
+
+func signedRange(bits int) (int, int) {
+	return -(1 << (bits - 1)), 1<<(bits-1) - 1
+}
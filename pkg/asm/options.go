@@ -0,0 +1,109 @@
+package asm
+
+import (
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+	"github.com/alexey-medvedchikov/lc3/pkg/preproc"
+)
+
+type config struct {
+	filename    string
+	format      Format
+	pseudoOps   bool
+	jmpt        bool
+	relax       bool
+	relocatable bool
+	preproc     []preproc.Option
+}
+
+type Option func(c *config)
+
+func newConfig(opts []Option) config {
+	c := config{format: FormatObj}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return c
+}
+
+// parserOptions returns the options a program has to be parsed with
+func (c config) parserOptions() []parser.Option {
+	var opts []parser.Option
+	if c.pseudoOps {
+		opts = append(opts, parser.WithPseudoOps())
+	}
+	if c.jmpt {
+		opts = append(opts, parser.WithJMPT())
+	}
+
+	return opts
+}
+
+// WithFilename names the source in error positions, relative .INCLUDE paths
+// are looked up next to it
+func WithFilename(name string) Option {
+	return func(c *config) {
+		c.filename = name
+	}
+}
+
+// WithResolver sets the way .INCLUDE files are found
+func WithResolver(r preproc.Resolver) Option {
+	return func(c *config) {
+		c.preproc = append(c.preproc, preproc.WithResolver(r))
+	}
+}
+
+// WithIncludeDirs adds search paths for .INCLUDE files
+func WithIncludeDirs(dirs ...string) Option {
+	return func(c *config) {
+		c.preproc = append(c.preproc, preproc.WithIncludeDirs(dirs...))
+	}
+}
+
+// WithDefine defines a symbol for conditional assembly as .DEFINE does
+func WithDefine(name, value string) Option {
+	return func(c *config) {
+		c.preproc = append(c.preproc, preproc.WithDefine(name, value))
+	}
+}
+
+// WithFormat sets the format Image.Write uses, FormatObj by default
+func WithFormat(f Format) Option {
+	return func(c *config) {
+		c.format = f
+	}
+}
+
+// WithPseudoOps enables pseudo-instructions, a program given to
+// AssembleProgram has to be parsed with parser.WithPseudoOps as well
+func WithPseudoOps() Option {
+	return func(c *config) {
+		c.pseudoOps = true
+	}
+}
+
+// WithJMPT makes Assemble accept JMPT, a program given to AssembleProgram
+// has to be parsed with parser.WithJMPT instead
+func WithJMPT() Option {
+	return func(c *config) {
+		c.jmpt = true
+	}
+}
+
+// WithRelax enables relaxation of BR, JSR and LD whose targets are out of
+// range of their PC-relative offsets
+func WithRelax() Option {
+	return func(c *config) {
+		c.relax = true
+	}
+}
+
+// WithRelocatable assembles a module for the linker: .EXTERNAL symbols may be
+// referred to and the image carries relocations of every word depending on
+// addresses, see WriteObject
+func WithRelocatable() Option {
+	return func(c *config) {
+		c.relocatable = true
+	}
+}
@@ -0,0 +1,287 @@
+package asm
+
+import (
+	"github.com/alexey-medvedchikov/lc3/pkg/bytecode"
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+// pseudoOp describes an extended instruction expanding into base ones, the
+// stack grows down from R6 which points to the next free word like
+// bytecode.TrapPUTS does
+type pseudoOp struct {
+	// size returns the number of words the expansion occupies, it is called
+	// by the first pass, validates the operands and reserves literals
+	size func(a *assembler, op *parser.Op) (int, error)
+	// emit produces the words in the second pass
+	emit func(a *assembler, op *parser.Op) error
+}
+
+var pseudoOps = map[string]pseudoOp{
+	// MOV rd, rs - ADD rd, rs, #0
+	"mov": {size: fixedSize(2, 1), emit: emitMov},
+	// CLR r - AND r, r, #0
+	"clr": {size: fixedSize(1, 1), emit: emitClr},
+	// SUB rd, rs1, rs2|imm - rd = rs1 - rs2 leaving rs2 intact
+	"sub": {size: sizeSub, emit: emitSub},
+	// NEG rd [, rs] - rd = -rs, rs defaults to rd
+	"neg": {size: sizeNeg, emit: emitNeg},
+	// INC r, DEC r - ADD r, r, #1 and ADD r, r, #-1
+	"inc": {size: fixedSize(1, 1), emit: emitStep(1)},
+	"dec": {size: fixedSize(1, 1), emit: emitStep(-1)},
+	// PUSH r - STR r, R6, #0; ADD R6, R6, #-1
+	"push": {size: fixedSize(1, 2), emit: emitPush},
+	// POP r - ADD R6, R6, #1; LDR r, R6, #0
+	"pop": {size: fixedSize(1, 2), emit: emitPop},
+	// CALL target - LD R7, =target; JSRR R7, reaches the whole memory
+	"call": {size: literalSize(1, 2), emit: emitCall},
+	// LDIMM r, value - LD r, =value for any 16-bit value
+	"ldimm": {size: literalSize(2, 1), emit: emitLdimm},
+}
+
+func (a *assembler) pseudoOp(name string) (pseudoOp, bool) {
+	if !a.pseudoOps {
+		return pseudoOp{}, false
+	}
+	p, ok := pseudoOps[name]
+
+	return p, ok
+}
+
+func fixedSize(args, size int) func(a *assembler, op *parser.Op) (int, error) {
+	return func(a *assembler, op *parser.Op) (int, error) {
+		if err := checkArgs(op, args); err != nil {
+			return 0, err
+		}
+		return size, nil
+	}
+}
+
+// literalSize is fixedSize for expansions loading a value from the pool
+func literalSize(args, size int) func(a *assembler, op *parser.Op) (int, error) {
+	return func(a *assembler, op *parser.Op) (int, error) {
+		if err := checkArgs(op, args); err != nil {
+			return 0, err
+		}
+		a.pool.size++
+		return size, nil
+	}
+}
+
+func emitMov(a *assembler, op *parser.Op) error {
+	dr, sr, err := registers2(op)
+	if err != nil {
+		return err
+	}
+	a.emit(bytecode.AddImm(dr, sr, 0))
+
+	return nil
+}
+
+func emitClr(a *assembler, op *parser.Op) error {
+	r, err := register(op.Args[0])
+	if err != nil {
+		return err
+	}
+	a.emit(bytecode.AndImm(r, r, 0))
+
+	return nil
+}
+
+func sizeSub(a *assembler, op *parser.Op) (int, error) {
+	if err := checkArgs(op, 3); err != nil {
+		return 0, err
+	}
+	if op.Args[2].Register == nil {
+		return 1, nil
+	}
+
+	dr, err := register(op.Args[0])
+	if err != nil {
+		return 0, err
+	}
+	sr1, err := register(op.Args[1])
+	if err != nil {
+		return 0, err
+	}
+	sr2, err := register(op.Args[2])
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case sr1 == sr2:
+		return 1, nil
+	case dr == sr2:
+		return 3, nil
+	}
+
+	return 5, nil
+}
+
+func emitSub(a *assembler, op *parser.Op) error {
+	dr, sr1, err := registers2(op)
+	if err != nil {
+		return err
+	}
+
+	if op.Args[2].Register == nil {
+		imm, err := a.number(op.Args[2].Pos, op.Args[2].Expr)
+		if err != nil {
+			return err
+		}
+		imm5, err := checkSigned(op.Args[2], -imm, 5)
+		if err != nil {
+			return err
+		}
+		a.emit(bytecode.AddImm(dr, sr1, imm5))
+		return nil
+	}
+
+	sr2 := *op.Args[2].Register
+	switch {
+	case sr1 == sr2:
+		a.emit(bytecode.AndImm(dr, dr, 0))
+	case dr == sr2:
+		a.emit(negate(dr, sr2)...)
+		a.emit(bytecode.AddReg(dr, sr1, dr))
+	default:
+		a.emit(negate(sr2, sr2)...)
+		a.emit(bytecode.AddReg(dr, sr1, sr2))
+		a.emit(negate(sr2, sr2)...)
+	}
+
+	return nil
+}
+
+func sizeNeg(a *assembler, op *parser.Op) (int, error) {
+	if len(op.Args) != 1 {
+		if err := checkArgs(op, 2); err != nil {
+			return 0, err
+		}
+	}
+
+	return 2, nil
+}
+
+func emitNeg(a *assembler, op *parser.Op) error {
+	dr, err := register(op.Args[0])
+	if err != nil {
+		return err
+	}
+	sr := dr
+	if len(op.Args) == 2 {
+		if sr, err = register(op.Args[1]); err != nil {
+			return err
+		}
+	}
+	a.emit(negate(dr, sr)...)
+
+	return nil
+}
+
+func emitStep(step int16) func(a *assembler, op *parser.Op) error {
+	return func(a *assembler, op *parser.Op) error {
+		r, err := register(op.Args[0])
+		if err != nil {
+			return err
+		}
+		a.emit(bytecode.AddImm(r, r, step))
+
+		return nil
+	}
+}
+
+func emitPush(a *assembler, op *parser.Op) error {
+	r, err := register(op.Args[0])
+	if err != nil {
+		return err
+	}
+	a.emit(
+		bytecode.STR(r, bytecode.R6, 0),
+		bytecode.AddImm(bytecode.R6, bytecode.R6, -1),
+	)
+
+	return nil
+}
+
+func emitPop(a *assembler, op *parser.Op) error {
+	r, err := register(op.Args[0])
+	if err != nil {
+		return err
+	}
+	a.emit(
+		bytecode.AddImm(bytecode.R6, bytecode.R6, 1),
+		bytecode.LDR(r, bytecode.R6, 0),
+	)
+
+	return nil
+}
+
+func emitCall(a *assembler, op *parser.Op) error {
+	ld, err := a.loadLiteral(op, bytecode.LD, bytecode.R7, op.Args[0], 0)
+	if err != nil {
+		return err
+	}
+	a.emit(ld, bytecode.JSRR(bytecode.R7))
+
+	return nil
+}
+
+func emitLdimm(a *assembler, op *parser.Op) error {
+	r, err := register(op.Args[0])
+	if err != nil {
+		return err
+	}
+	ld, err := a.loadLiteral(op, bytecode.LD, r, op.Args[1], 0)
+	if err != nil {
+		return err
+	}
+	a.emit(ld)
+
+	return nil
+}
+
+// loadLiteral puts the value of arg into the literal pool and returns LD or
+// LDI of it into r, at is the index of the load within the expansion
+func (a *assembler) loadLiteral(
+	op *parser.Op, load func(bytecode.Register, int16) uint16, r bytecode.Register, arg *parser.OpArgs, at uint16,
+) (uint16, error) {
+	if arg.Expr == nil {
+		return 0, errorf(arg.Pos, "value expected")
+	}
+	v, err := a.wordValue(arg.Pos, arg.Expr)
+	if err != nil {
+		return 0, err
+	}
+
+	addr := a.literal(uint16(v.v))
+	if err := a.relocate(arg.Pos, addr, RelocAbs16, v); err != nil {
+		return 0, err
+	}
+	pc := a.pc + at
+	offset := int(addr) - int(pc+1)
+	if offset < -256 || offset > 255 {
+		return 0, errorf(op.Pos, "literal pool at x%04X is out of LD range from x%04X, the segment is too large",
+			addr, pc)
+	}
+
+	return load(r, int16(offset)), nil
+}
+
+func registers2(op *parser.Op) (bytecode.Register, bytecode.Register, error) {
+	r1, err := register(op.Args[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	r2, err := register(op.Args[1])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return r1, r2, nil
+}
+
+// negate computes dr = -sr in two's complement
+func negate(dr, sr bytecode.Register) []uint16 {
+	return []uint16{bytecode.Not(dr, sr), bytecode.AddImm(dr, dr, 1)}
+}
@@ -0,0 +1,100 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/bytecode"
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+func TestAssemble_PseudoOps(t *testing.T) {
+	img, err := assemble(t, `
+        .ORIG x3000
+        MOV R1, R2
+        CLR R3
+        SUB R1, R2, #3
+        SUB R1, R2, R3
+        SUB R3, R2, R3
+        SUB R1, R2, R2
+        NEG R4
+        NEG R4, R5
+        INC R1
+        DEC R1
+        PUSH R1
+        POP R2
+        CALL FUNC
+        LDIMM R0, x1234
+FUNC    RET
+        .END
+`, WithPseudoOps())
+	require.NoError(t, err)
+
+	R0, R1, R2, R3, R4, R5, R6, R7 := bytecode.R0, bytecode.R1, bytecode.R2, bytecode.R3,
+		bytecode.R4, bytecode.R5, bytecode.R6, bytecode.R7
+	assert.Equal(t, []uint16{
+		bytecode.AddImm(R1, R2, 0),
+		bytecode.AndImm(R3, R3, 0),
+		bytecode.AddImm(R1, R2, -3),
+		bytecode.Not(R3, R3), bytecode.AddImm(R3, R3, 1),
+		bytecode.AddReg(R1, R2, R3),
+		bytecode.Not(R3, R3), bytecode.AddImm(R3, R3, 1),
+		bytecode.Not(R3, R3), bytecode.AddImm(R3, R3, 1),
+		bytecode.AddReg(R3, R2, R3),
+		bytecode.AndImm(R1, R1, 0),
+		bytecode.Not(R4, R4), bytecode.AddImm(R4, R4, 1),
+		bytecode.Not(R4, R5), bytecode.AddImm(R4, R4, 1),
+		bytecode.AddImm(R1, R1, 1),
+		bytecode.AddImm(R1, R1, -1),
+		bytecode.STR(R1, R6, 0), bytecode.AddImm(R6, R6, -1),
+		bytecode.AddImm(R6, R6, 1), bytecode.LDR(R2, R6, 0),
+		bytecode.LD(R7, 3), bytecode.JSRR(R7), // x3016
+		bytecode.LD(R0, 2), // x3018
+		bytecode.RET(),     // x3019
+		0x3019, 0x1234,     // literal pool at x301A
+	}, img.Segments[0].Words)
+
+	// the whole expansion maps to its source line
+	var entries []SourceEntry
+	for _, e := range img.SourceMap {
+		if e.Pos.Line == 6 || e.Pos.Line == 18 {
+			entries = append(entries, e)
+		}
+	}
+	require.Len(t, entries, 2)
+	assert.Equal(t, uint16(0x3003), entries[0].Addr)
+	assert.Len(t, entries[0].Words, 5)
+	assert.Equal(t, uint16(0x301A), entries[1].Addr)
+	assert.Equal(t, []uint16{0x3019, 0x1234}, entries[1].Words)
+}
+
+func TestAssemble_PseudoOpsDisabled(t *testing.T) {
+	_, err := parser.Parse(strings.NewReader("        INC R1\n"))
+	assert.Error(t, err)
+
+	prog, err := parser.Parse(strings.NewReader(".ORIG x3000\nINC ADD R1, R1, #1\n.END\n"))
+	require.NoError(t, err)
+	img, err := AssembleProgram(prog)
+	require.NoError(t, err)
+	assert.Equal(t, SymbolTable{"INC": 0x3000}, img.Symbols)
+}
+
+func TestAssemble_PseudoOpsErrors(t *testing.T) {
+	tests := map[string]string{
+		"operands":    ".ORIG x3000\nMOV R1\n.END",
+		"sub range":   ".ORIG x3000\nSUB R1, R1, #-16\n.END",
+		"neg args":    ".ORIG x3000\nNEG\n.END",
+		"ldimm range": ".ORIG x3000\nLDIMM R1, x10000\n.END",
+		"pool range":  ".ORIG x3000\nLDIMM R1, 1\n.BLKW 300\n.END",
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := assemble(t, src, WithPseudoOps())
+			assert.Error(t, err)
+		})
+	}
+}
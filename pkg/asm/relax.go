@@ -0,0 +1,144 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/bytecode"
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+// Far operations are relaxed into sequences reaching the whole memory through
+// an address in the literal pool:
+//
+//	BRnzp FAR  - LD R7, =FAR; JMP R7
+//	BRcc FAR   - BR!cc #2; LD R7, =FAR; JMP R7
+//	JSR FAR    - LD R7, =FAR; JSRR R7
+//	LD R, FAR  - LDI R, =FAR
+//
+// Relaxed branches clobber R7 the same way JSR and TRAP do.
+
+// relaxTarget returns the target operand of an operation that may be relaxed
+// and the width of its offset
+func relaxTarget(op *parser.Op) (*parser.OpArgs, int, bool) {
+	name := strings.ToLower(*op.OpCode)
+	switch {
+	case name == "jsr" && len(op.Args) == 1:
+		return op.Args[0], 11, true
+	case name == "ld" && len(op.Args) == 2:
+		return op.Args[1], 9, true
+	case strings.HasPrefix(name, "br") && len(op.Args) == 1:
+		return op.Args[0], 9, true
+	}
+
+	return nil, 0, false
+}
+
+// relaxFar marks operations whose targets are out of range with the
+// addresses of the last first pass, it reports whether any were found
+func (a *assembler) relaxFar(prog *parser.Program) bool {
+	changed := false
+	a.segIdx = -1
+	for i, st := range prog.Statements {
+		if isDirective(st.Directive, ".orig") || isDirective(st.Directive, ".section") {
+			a.segIdx++
+			continue
+		}
+		if st.Op == nil || a.relaxed[i] {
+			continue
+		}
+		if _, ok := a.pseudoOp(strings.ToLower(*st.Op.OpCode)); ok {
+			continue
+		}
+		arg, bits, ok := relaxTarget(st.Op)
+		if !ok || arg.Expr == nil {
+			continue
+		}
+
+		a.stmt, a.pc = i, a.addrs[i]
+		v, err := a.eval(arg.Expr)
+		// errors are reported by the second pass, targets in other sections
+		// are up to the linker
+		if err != nil || v.rel != 1 || v.ext != "" || a.crossSection(v) {
+			continue
+		}
+		if lo, hi := signedRange(bits); v.v-int(a.pc+1) < lo || v.v-int(a.pc+1) > hi {
+			a.relaxed[i] = true
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func relaxedSize(name string) int {
+	switch {
+	case name == "ld":
+		return 1
+	case name == "jsr", branchFlags(name) == 0b111:
+		return 2
+	}
+
+	return 3
+}
+
+// warnRelaxed tells where a far operation was relaxed and what it became,
+// branches are pointed out as they clobber R7 unlike the original ones
+func (a *assembler) warnRelaxed(name string, op *parser.Op) {
+	var msg string
+	switch {
+	case name == "ld":
+		msg = "relaxed into LDI through the literal pool"
+	case name == "jsr":
+		msg = "relaxed into LD R7 and JSRR R7"
+	default:
+		msg = "relaxed into LD R7 and JMP R7, R7 is clobbered"
+	}
+	a.warnings = append(a.warnings, &Error{
+		Pos: op.Pos,
+		Msg: fmt.Sprintf("far %s at x%04X %s", *op.OpCode, a.pc, msg),
+	})
+}
+
+func (a *assembler) emitRelaxed(name string, op *parser.Op) error {
+	a.warnRelaxed(name, op)
+	if name == "ld" {
+		r, err := register(op.Args[0])
+		if err != nil {
+			return err
+		}
+		ldi, err := a.loadLiteral(op, bytecode.LDI, r, op.Args[1], 0)
+		if err != nil {
+			return err
+		}
+		a.emit(ldi)
+		return nil
+	}
+
+	if name == "jsr" {
+		ld, err := a.loadLiteral(op, bytecode.LD, bytecode.R7, op.Args[0], 0)
+		if err != nil {
+			return err
+		}
+		a.emit(ld, bytecode.JSRR(bytecode.R7))
+		return nil
+	}
+
+	nzp := branchFlags(name)
+	if nzp == 0b111 {
+		ld, err := a.loadLiteral(op, bytecode.LD, bytecode.R7, op.Args[0], 0)
+		if err != nil {
+			return err
+		}
+		a.emit(ld, bytecode.JMP(bytecode.R7))
+		return nil
+	}
+
+	ld, err := a.loadLiteral(op, bytecode.LD, bytecode.R7, op.Args[0], 1)
+	if err != nil {
+		return err
+	}
+	a.emit(bytecode.BRx(^nzp&0b111, 2), ld, bytecode.JMP(bytecode.R7))
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package asm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssemble_Relax(t *testing.T) {
+	src := `
+        .ORIG x3000
+        BRz FAR
+        BR FAR
+        JSR FAR
+        LD R1, FAR
+        BR NEAR
+NEAR    HALT
+        .END
+
+        .ORIG x4000
+FAR     RET
+        .END
+`
+	img, err := assemble(t, src, WithRelax())
+	require.NoError(t, err)
+
+	assert.Equal(t, []uint16{
+		0x0A02, 0x2E08, 0xC1C0, // BRnp #2; LD R7, =FAR; JMP R7
+		0x2E07, 0xC1C0, // LD R7, =FAR; JMP R7
+		0x2E06, 0x41C0, // LD R7, =FAR; JSRR R7
+		0xA205,                         // LDI R1, =FAR
+		0x0E00,                         // BR NEAR
+		0xF025,                         // HALT
+		0x4000, 0x4000, 0x4000, 0x4000, // literal pool
+	}, img.Segments[0].Words)
+	assert.Equal(t, uint16(0x3009), img.Symbols["NEAR"])
+
+	var warnings []string
+	for _, w := range img.Warnings {
+		warnings = append(warnings, fmt.Sprintf("%d: %s", w.Pos.Line, w.Msg))
+	}
+	assert.Equal(t, []string{
+		"3: far BRz at x3000 relaxed into LD R7 and JMP R7, R7 is clobbered",
+		"4: far BR at x3003 relaxed into LD R7 and JMP R7, R7 is clobbered",
+		"5: far JSR at x3005 relaxed into LD R7 and JSRR R7",
+		"6: far LD at x3007 relaxed into LDI through the literal pool",
+	}, warnings)
+
+	_, err = assemble(t, src)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "target x4000 is 4095 words away from PC x3001, out of 9-bit offset range [-256, 255]")
+}
+
+func TestAssemble_RelaxConverges(t *testing.T) {
+	// relaxing BR FAR moves BR START out of range
+	img, err := assemble(t, `
+        .ORIG x3000
+START   HALT
+        .BLKW 253
+        BR FAR
+        BR START
+        .END
+
+        .ORIG x4000
+FAR     RET
+        .END
+`, WithRelax())
+	require.NoError(t, err)
+
+	assert.Equal(t, []uint16{0x2E03, 0xC1C0, 0x2E02, 0xC1C0, 0x4000, 0x3000}, img.Segments[0].Words[0xFE:])
+}
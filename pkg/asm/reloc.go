@@ -0,0 +1,183 @@
+package asm
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+type RelocKind string
+
+const (
+	// RelocAbs16 is a word holding an address
+	RelocAbs16 RelocKind = "abs16"
+	// RelocPC9 and RelocPC11 are PC-relative offsets of BR, LD, LDI, LEA,
+	// ST, STI and of JSR
+	RelocPC9  RelocKind = "pc9"
+	RelocPC11 RelocKind = "pc11"
+)
+
+// Relocation is a word at Addr of the segment with index Segment the linker
+// has to patch. Symbol names an external symbol the word refers to with
+// Addend added. An empty Symbol means an address within the segment with
+// index Target, an abs16 word holds it while Addend holds it for offsets.
+type Relocation struct {
+	Segment int `json:",omitempty"`
+	Addr    uint16
+	Kind    RelocKind
+	Symbol  string `json:",omitempty"`
+	Target  int    `json:",omitempty"`
+	Addend  int    `json:",omitempty"`
+}
+
+// section handles ".SECTION name" starting a segment the linker places into
+// a memory region by the section name
+func (a *assembler) section(st *parser.Statement) (string, error) {
+	d := st.Directive
+	if len(st.Labels) != 0 {
+		return "", errorf(st.Labels[0].Pos, "labels are not allowed on .SECTION")
+	}
+	if len(d.Args) != 1 {
+		return "", errorf(d.Pos, ".SECTION expects a section name")
+	}
+	name, ok := symbolName(d.Args[0])
+	if !ok {
+		return "", errorf(d.Args[0].Pos, ".SECTION expects a section name")
+	}
+	if !a.relocatable {
+		return name, errorf(d.Pos, ".SECTION is only allowed in relocatable modules")
+	}
+
+	return name, nil
+}
+
+func (a *assembler) inSection(seg int) bool {
+	return seg < len(a.segs) && a.segs[seg].Section != ""
+}
+
+// crossSection reports whether an address points into a section other than
+// the current one, the distance between them is up to the linker
+func (a *assembler) crossSection(v value) bool {
+	return v.rel == 1 && v.ext == "" && v.seg != a.segIdx && (a.inSection(v.seg) || a.inSection(a.segIdx))
+}
+
+func (a *assembler) symbolSegments() map[string]int {
+	if !a.relocatable {
+		return nil
+	}
+
+	return a.symSegs
+}
+
+func isLinkage(d *parser.Directive) bool {
+	return isDirective(d, ".global") || isDirective(d, ".external")
+}
+
+// linkage handles ".GLOBAL name, ..." exporting labels of the module and
+// ".EXTERNAL name, ..." importing symbols of other modules
+func (a *assembler) linkage(st *parser.Statement) error {
+	d := st.Directive
+	name := strings.ToUpper(*d.Name)
+	if len(st.Labels) != 0 {
+		return errorf(st.Labels[0].Pos, "labels are not allowed on %s", name)
+	}
+	if len(d.Args) == 0 {
+		return errorf(d.Pos, "%s expects symbol names", name)
+	}
+
+	for _, arg := range d.Args {
+		sym, ok := symbolName(arg)
+		if !ok {
+			return errorf(arg.Pos, "%s expects symbol names", name)
+		}
+		if isDirective(d, ".global") {
+			a.globals[sym] = arg.Pos
+		} else {
+			a.externs[sym] = arg.Pos
+		}
+	}
+
+	return nil
+}
+
+// symbolName returns the name of a directive argument being a single label
+func symbolName(arg *parser.DirectiveArg) (string, bool) {
+	e := arg.Expr
+	if e == nil || len(e.Right) != 0 || len(e.Left.Right) != 0 || e.Left.Left.Operand == nil {
+		return "", false
+	}
+	o := e.Left.Left.Operand
+	if o.Label == nil || parser.IsLocalLabel(*o.Label) {
+		return "", false
+	}
+
+	return *o.Label, true
+}
+
+// checkLinkage reports globals that are not labels of the module and
+// externals defined in it
+func (a *assembler) checkLinkage() {
+	for _, name := range sortedNames(a.globals) {
+		if _, ok := a.symbols[name]; !ok {
+			a.fail(errorf(a.globals[name], "undefined global label '%s'%s", name, a.suggestLabel(name)))
+		}
+	}
+	for _, name := range sortedNames(a.externs) {
+		if a.defined(name) {
+			a.fail(errorf(a.externs[name], "external symbol '%s' is defined in the module", name))
+		}
+	}
+}
+
+func (a *assembler) globalNames() []string {
+	if !a.relocatable {
+		return nil
+	}
+
+	return sortedNames(a.globals)
+}
+
+func sortedNames(m map[string]lexer.Position) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// relocate records a relocation of the word at addr holding v if it depends
+// on an address, pos is the position of the expression of v
+func (a *assembler) relocate(pos lexer.Position, addr uint16, kind RelocKind, v value) error {
+	if v.rel == 0 {
+		return nil
+	}
+	if !a.relocatable {
+		if v.ext != "" {
+			return errorf(pos, "external symbol '%s' is only resolved in relocatable modules", v.ext)
+		}
+		return nil
+	}
+
+	r := Relocation{Segment: a.segIdx, Addr: addr, Kind: kind, Symbol: v.ext}
+	switch {
+	case v.ext != "":
+		r.Addend = v.v
+	case kind == RelocAbs16:
+		r.Target = v.seg
+	default:
+		r.Target, r.Addend = v.seg, v.v
+	}
+	a.relocs = append(a.relocs, r)
+
+	return nil
+}
+
+// here returns the address of the next word emitted
+func (a *assembler) here() uint16 {
+	return a.seg.Origin + uint16(len(a.seg.Words))
+}
@@ -0,0 +1,133 @@
+package asm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssemble_Relocatable(t *testing.T) {
+	src := `
+        .ORIG x3000
+        .EXTERNAL PRINT, TABLE
+        .GLOBAL MAIN
+MAIN    JSR PRINT
+        LEA R0, TABLE+2
+        LD R1, PTR
+        HALT
+PTR     .FILL MAIN
+        .FILL TABLE+1
+        .FILL 7
+        .END
+`
+	img, err := Assemble(strings.NewReader(src), WithRelocatable())
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"MAIN"}, img.Globals)
+	assert.Equal(t, []Relocation{
+		{Addr: 0x3000, Kind: RelocPC11, Symbol: "PRINT"},
+		{Addr: 0x3001, Kind: RelocPC9, Symbol: "TABLE", Addend: 2},
+		{Addr: 0x3004, Kind: RelocAbs16},
+		{Addr: 0x3005, Kind: RelocAbs16, Symbol: "TABLE", Addend: 1},
+	}, img.Relocations)
+	assert.Equal(t, []uint16{0x4800, 0xE000, 0x2201, 0xF025, 0x3000, 1, 7}, img.Segments[0].Words)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteObject(&buf, img))
+	obj, err := ReadObject(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, img.Segments, obj.Segments)
+	assert.Equal(t, img.Symbols, obj.Symbols)
+	assert.Equal(t, img.Relocations, obj.Relocations)
+}
+
+func TestAssemble_RelocatableErrors(t *testing.T) {
+	tests := map[string]string{
+		"undefined global":   ".ORIG x3000\n.GLOBAL NOPE\n.END",
+		"defined external":   ".ORIG x3000\n.EXTERNAL A\nA RET\n.END",
+		"external negated":   ".ORIG x3000\n.EXTERNAL A\n.FILL 0-A\n.END",
+		"external sum":       ".ORIG x3000\n.EXTERNAL A\nB .FILL A+B\n.END",
+		"external constant":  ".ORIG x3000\n.EXTERNAL A\n.BLKW A\n.END",
+		"external immediate": ".ORIG x3000\n.EXTERNAL A\nADD R0, R0, A\n.END",
+		"global expression":  ".ORIG x3000\nA RET\n.GLOBAL A+1\n.END",
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Assemble(strings.NewReader(src), WithRelocatable())
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := assemble(t, ".ORIG x3000\n.EXTERNAL A\nJSR A\n.END")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "external symbol 'A' is only resolved in relocatable modules")
+}
+
+func TestAssemble_Sections(t *testing.T) {
+	src := `
+        .SECTION text
+        .EXTERNAL PRINT
+MAIN    LEA R0, MSG
+        JSR PRINT
+        LD R1, PTR
+        BR MAIN
+PTR     .FILL MSG+1
+        .SECTION data
+MSG     .STRINGZ "hi"
+        .END
+`
+	img, err := Assemble(strings.NewReader(src), WithRelocatable())
+	require.NoError(t, err)
+
+	require.Len(t, img.Segments, 2)
+	assert.Equal(t, "text", img.Segments[0].Section)
+	assert.Equal(t, "data", img.Segments[1].Section)
+	assert.Equal(t, []uint16{0xE000, 0x4800, 0x2201, 0x0FFC, 1}, img.Segments[0].Words)
+	assert.Equal(t, []uint16{'h', 'i', 0}, img.Segments[1].Words)
+	assert.Equal(t, map[string]int{"MAIN": 0, "PTR": 0, "MSG": 1}, img.SymbolSegments)
+	assert.Equal(t, []Relocation{
+		{Addr: 0, Kind: RelocPC9, Target: 1},
+		{Addr: 1, Kind: RelocPC11, Symbol: "PRINT"},
+		{Addr: 4, Kind: RelocAbs16, Target: 1},
+	}, img.Relocations)
+}
+
+func TestAssemble_SectionErrors(t *testing.T) {
+	tests := map[string]struct {
+		src  string
+		opts []Option
+		msg  string
+	}{
+		"not relocatable": {
+			src: ".SECTION text\nRET\n.END",
+			msg: ".SECTION is only allowed in relocatable modules",
+		},
+		"no name": {
+			src:  ".SECTION\nRET\n.END",
+			opts: []Option{WithRelocatable()},
+			msg:  ".SECTION expects a section name",
+		},
+		"label": {
+			src:  "A .SECTION text\nRET\n.END",
+			opts: []Option{WithRelocatable()},
+			msg:  "labels are not allowed on .SECTION",
+		},
+		"distance between sections": {
+			src:  ".SECTION text\nA RET\n.SECTION data\nB .FILL B-A\n.END",
+			opts: []Option{WithRelocatable()},
+			msg:  "addresses of different sections can not be combined",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Assemble(strings.NewReader(tt.src), tt.opts...)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.msg)
+		})
+	}
+}
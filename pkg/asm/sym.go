@@ -0,0 +1,62 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+// WriteSym writes the symbol table in the layout of the classic lc3as
+// .sym files, ordered by address
+func WriteSym(w io.Writer, symbols SymbolTable) error {
+	names := make([]string, 0, len(symbols))
+	for name := range symbols {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if symbols[names[i]] != symbols[names[j]] {
+			return symbols[names[i]] < symbols[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprint(bw, "// Symbol table\n")
+	_, _ = fmt.Fprint(bw, "// Scope level 0:\n")
+	_, _ = fmt.Fprint(bw, "//\tSymbol Name       Page Address\n")
+	_, _ = fmt.Fprint(bw, "//\t----------------  ------------\n")
+	for _, name := range names {
+		_, _ = fmt.Fprintf(bw, "//\t%-16s  %04X\n", name, symbols[name])
+	}
+	_, _ = fmt.Fprint(bw, "\n")
+
+	return bw.Flush()
+}
+
+// ReadSym reads a symbol table written by WriteSym or the classic lc3as,
+// lines other than "//	NAME  ADDR" ones are skipped
+func ReadSym(r io.Reader) (SymbolTable, error) {
+	symbols := SymbolTable{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(strings.TrimPrefix(sc.Text(), "//"))
+		if len(fields) != 2 || !parser.IsLabel(fields[0]) {
+			continue
+		}
+		addr, err := strconv.ParseUint(fields[1], 16, 16)
+		if err != nil {
+			continue
+		}
+		symbols[fields[0]] = uint16(addr)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return symbols, nil
+}
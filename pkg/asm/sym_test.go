@@ -0,0 +1,39 @@
+package asm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSym(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := WriteSym(&buf, SymbolTable{
+		"LOOP":  0x3002,
+		"START": 0x3000,
+		"DATA":  0x3002,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `// Symbol table
+// Scope level 0:
+//	Symbol Name       Page Address
+//	----------------  ------------
+//	START             3000
+//	DATA              3002
+//	LOOP              3002
+
+`, buf.String())
+}
+
+func TestReadSym(t *testing.T) {
+	symbols := SymbolTable{"START": 0x3000, "DATA": 0x3002, "LOOP": 0x3002}
+	var buf bytes.Buffer
+	require.NoError(t, WriteSym(&buf, symbols))
+
+	read, err := ReadSym(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, symbols, read)
+}
@@ -146,3 +146,12 @@ func Test_Trap(t *testing.T) {
 	op = Trap(0b1111_1111)
 	assert.Equal(t, uint16(0b1111_0000_11111111), op)
 }
+
+func Test_RegisterCapture(t *testing.T) {
+	var r Register
+	assert.NoError(t, r.Capture([]string{"r7"}))
+	assert.Equal(t, R7, r)
+
+	assert.Error(t, r.Capture([]string{"R8"}))
+	assert.Error(t, r.Capture([]string{"R12"}))
+}
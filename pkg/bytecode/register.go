@@ -28,9 +28,9 @@ func (r *Register) Capture(values []string) error {
 	}
 
 	v := values[0]
-	i, err := strconv.ParseUint(v[1:], 10, 4)
-	if err != nil {
-		return err
+	i, err := strconv.ParseUint(v[1:], 10, 8)
+	if err != nil || i > uint64(R7) {
+		return fmt.Errorf("unknown register '%s'", v)
 	}
 	*r = Register(i)
 
@@ -0,0 +1,166 @@
+package disasm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/bytecode"
+	"github.com/alexey-medvedchikov/lc3/pkg/machine"
+)
+
+// instruction is a decoded word
+type instruction struct {
+	mnemonic string
+	operands []string
+	// target is the address a PC-relative operand refers to, it is written
+	// as the last operand
+	target int
+	// jump tells that control may go to target
+	jump bool
+	// next tells that execution may go on to the following word
+	next bool
+}
+
+// decoder turns what machine.Decode calls into an instruction
+type decoder struct {
+	// pc is the address following the word being decoded
+	pc  uint16
+	ins *instruction
+}
+
+var _ machine.Executor = &decoder{}
+
+// decode returns the instruction a word at addr stands for, words that
+// would not assemble back into themselves are not instructions
+func decode(addr uint16, word uint16) (*instruction, bool) {
+	d := decoder{pc: addr + 1}
+	if !machine.Decode(&d, word) || d.ins == nil {
+		return nil, false
+	}
+
+	return d.ins, true
+}
+
+func (d *decoder) op(mnemonic string, operands ...interface{}) {
+	d.ins = &instruction{mnemonic: mnemonic, target: -1, next: true}
+	for _, o := range operands {
+		d.ins.operands = append(d.ins.operands, fmt.Sprint(o))
+	}
+}
+
+func (d *decoder) pcRelative(mnemonic string, offset int16, operands ...interface{}) {
+	d.op(mnemonic, operands...)
+	d.ins.target = int(d.pc + uint16(offset))
+}
+
+func imm(v int16) string {
+	return fmt.Sprintf("#%d", v)
+}
+
+func (d *decoder) AddReg(dstReg machine.Register, srcReg1 machine.Register, srcReg2 machine.Register) {
+	d.op("ADD", dstReg, srcReg1, srcReg2)
+}
+
+func (d *decoder) AddImm(dstReg machine.Register, srcReg1 machine.Register, imm5 int16) {
+	d.op("ADD", dstReg, srcReg1, imm(imm5))
+}
+
+func (d *decoder) AndReg(dstReg machine.Register, srcReg1 machine.Register, srcReg2 machine.Register) {
+	d.op("AND", dstReg, srcReg1, srcReg2)
+}
+
+func (d *decoder) AndImm(dstReg machine.Register, srcReg1 machine.Register, imm5 int16) {
+	d.op("AND", dstReg, srcReg1, imm(imm5))
+}
+
+// BRx leaves a branch that is never taken undecoded, no mnemonic stands
+// for it
+func (d *decoder) BRx(nzp byte, offset9 int16) {
+	if nzp == 0 {
+		return
+	}
+	var flags strings.Builder
+	for i, f := range "nzp" {
+		if nzp&(0b100>>i) != 0 {
+			flags.WriteRune(f)
+		}
+	}
+	d.pcRelative("BR"+flags.String(), offset9)
+	d.ins.jump = true
+	d.ins.next = nzp != 0b111
+}
+
+func (d *decoder) JMP(baseReg machine.Register) {
+	if baseReg == machine.R7 {
+		d.op("RET")
+	} else {
+		d.op("JMP", baseReg)
+	}
+	d.ins.next = false
+}
+
+func (d *decoder) JSR(offset11 int16) {
+	d.pcRelative("JSR", offset11)
+	d.ins.jump = true
+}
+
+func (d *decoder) JSRR(baseReg machine.Register) {
+	d.op("JSRR", baseReg)
+}
+
+func (d *decoder) LD(dstReg machine.Register, offset9 int16) {
+	d.pcRelative("LD", offset9, dstReg)
+}
+
+func (d *decoder) LDI(dstReg machine.Register, offset9 int16) {
+	d.pcRelative("LDI", offset9, dstReg)
+}
+
+func (d *decoder) LDR(dstReg machine.Register, baseReg machine.Register, offset6 int16) {
+	d.op("LDR", dstReg, baseReg, imm(offset6))
+}
+
+func (d *decoder) LEA(dstReg machine.Register, offset9 int16) {
+	d.pcRelative("LEA", offset9, dstReg)
+}
+
+func (d *decoder) Not(dstReg machine.Register, srcReg machine.Register) {
+	d.op("NOT", dstReg, srcReg)
+}
+
+func (d *decoder) RTI() {
+	d.op("RTI")
+	d.ins.next = false
+}
+
+func (d *decoder) ST(srcReg machine.Register, offset9 int16) {
+	d.pcRelative("ST", offset9, srcReg)
+}
+
+func (d *decoder) STI(srcReg machine.Register, offset9 int16) {
+	d.pcRelative("STI", offset9, srcReg)
+}
+
+func (d *decoder) STR(srcReg machine.Register, baseReg machine.Register, offset6 int16) {
+	d.op("STR", srcReg, baseReg, imm(offset6))
+}
+
+// trapAliases names the service routines of the trap vectors that have
+// names of their own
+var trapAliases = map[uint16]string{
+	bytecode.TrapGETCAddr:  "GETC",
+	bytecode.TrapOUTAddr:   "OUT",
+	bytecode.TrapPUTSAddr:  "PUTS",
+	bytecode.TrapINAddr:    "IN",
+	bytecode.TrapPUTSPAddr: "PUTSP",
+	bytecode.TrapHALTAddr:  "HALT",
+}
+
+func (d *decoder) Trap(vec8 uint8) {
+	if name, ok := trapAliases[uint16(vec8)]; ok {
+		d.op(name)
+	} else {
+		d.op("TRAP", fmt.Sprintf("x%02X", vec8))
+	}
+	d.ins.next = uint16(vec8) != bytecode.TrapHALTAddr
+}
@@ -0,0 +1,274 @@
+package disasm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/asm"
+	"github.com/alexey-medvedchikov/lc3/pkg/format"
+	"github.com/alexey-medvedchikov/lc3/pkg/machine"
+)
+
+// Option configures Disassemble
+type Option func(d *disassembler)
+
+// WithSymbols names addresses after a symbol table, such as one read from a
+// .sym file, other addresses code refers to get generated labels
+func WithSymbols(symbols asm.SymbolTable) Option {
+	return func(d *disassembler) {
+		d.symbols = symbols
+	}
+}
+
+// WithDebugInfo tells code from data with debug info written along with the
+// image instead of following the control flow
+func WithDebugInfo(info *asm.DebugInfo) Option {
+	return func(d *disassembler) {
+		d.debugInfo = info
+	}
+}
+
+type disassembler struct {
+	symbols   asm.SymbolTable
+	debugInfo *asm.DebugInfo
+
+	// words holds every word of the image by address
+	words map[uint16]uint16
+	// code marks addresses of words decoded as instructions
+	code   map[uint16]*instruction
+	labels map[uint16][]string
+}
+
+// Disassemble turns the segments of an image into LC-3 assembly source that
+// assembles back into the same image. Instructions are found by following
+// the control flow from the start of every segment and from the routines
+// of the trap and interrupt vector tables, PC-relative operands refer to
+// labels when their targets are within the image. Words that are not
+// reached or do not decode are written as data: .STRINGZ for NUL-terminated
+// text, .BLKW for runs of zeros and .FILL for the rest.
+func Disassemble(img *asm.Image, opts ...Option) ([]byte, error) {
+	d := disassembler{
+		words:  map[uint16]uint16{},
+		code:   map[uint16]*instruction{},
+		labels: map[uint16][]string{},
+	}
+	for _, opt := range opts {
+		opt(&d)
+	}
+
+	for _, seg := range img.Segments {
+		for i, w := range seg.Words {
+			d.words[seg.Origin+uint16(i)] = w
+		}
+	}
+	if d.debugInfo != nil {
+		d.decodeMarked()
+	} else {
+		d.followFlow(img.Segments)
+	}
+	d.nameTargets()
+
+	var b strings.Builder
+	for _, seg := range img.Segments {
+		d.writeSegment(&b, seg)
+	}
+
+	return format.Source("", []byte(b.String()))
+}
+
+// followFlow decodes instructions reachable from the start of every
+// segment
+func (d *disassembler) followFlow(segs []asm.Segment) {
+	var queue []uint16
+	for _, seg := range segs {
+		if len(seg.Words) != 0 && !isVector(seg.Origin) {
+			queue = append(queue, seg.Origin)
+		}
+	}
+	for addr, w := range d.words {
+		if isVector(addr) {
+			queue = append(queue, w)
+		}
+	}
+
+	for len(queue) != 0 {
+		addr := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		word, ok := d.words[addr]
+		if _, seen := d.code[addr]; seen || !ok || isVector(addr) {
+			continue
+		}
+		ins, ok := decode(addr, word)
+		if !ok {
+			continue
+		}
+		d.code[addr] = ins
+		if ins.next {
+			queue = append(queue, addr+1)
+		}
+		if ins.jump {
+			queue = append(queue, uint16(ins.target))
+		}
+	}
+}
+
+// decodeMarked decodes words debug info marks as code
+func (d *disassembler) decodeMarked() {
+	for _, w := range d.debugInfo.Words {
+		word, ok := d.words[w.Addr]
+		if !w.Code || !ok {
+			continue
+		}
+		if ins, ok := decode(w.Addr, word); ok {
+			d.code[w.Addr] = ins
+		}
+	}
+}
+
+// nameTargets labels addresses of the symbol table and targets of
+// instructions within the image
+func (d *disassembler) nameTargets() {
+	for name, addr := range d.symbols {
+		if _, ok := d.words[addr]; ok {
+			d.labels[addr] = append(d.labels[addr], name)
+		}
+	}
+	for _, names := range d.labels {
+		sort.Strings(names)
+	}
+
+	var targets []uint16
+	for _, ins := range d.code {
+		if ins.target != -1 {
+			targets = append(targets, uint16(ins.target))
+		}
+	}
+	for addr, w := range d.words {
+		if isVector(addr) {
+			targets = append(targets, w)
+		}
+	}
+	for _, addr := range targets {
+		if _, ok := d.words[addr]; !ok || len(d.labels[addr]) != 0 {
+			continue
+		}
+		d.labels[addr] = []string{d.generatedLabel(addr)}
+	}
+}
+
+// isVector reports whether addr belongs to the trap or the interrupt vector
+// table, these hold addresses of service routines
+func isVector(addr uint16) bool {
+	return addr < machine.PrivilegedStart
+}
+
+func (d *disassembler) generatedLabel(addr uint16) string {
+	name := fmt.Sprintf("L%04X", addr)
+	for {
+		if _, taken := d.symbols[name]; !taken {
+			return name
+		}
+		name += "_"
+	}
+}
+
+func (d *disassembler) writeSegment(b *strings.Builder, seg asm.Segment) {
+	fmt.Fprintf(b, ".ORIG x%04X\n", seg.Origin)
+
+	end := int(seg.Origin) + len(seg.Words)
+	for addr := int(seg.Origin); addr < end; {
+		labels := d.labels[uint16(addr)]
+		for _, name := range labels[min(1, len(labels)):] {
+			fmt.Fprintf(b, "%s\n", name)
+		}
+		if len(labels) != 0 {
+			b.WriteString(labels[0])
+		}
+
+		text, n := d.statement(uint16(addr), end)
+		fmt.Fprintf(b, " %s ; x%04X\n", text, addr)
+		addr += n
+	}
+
+	b.WriteString(".END\n\n")
+}
+
+// statement returns the text of the statement at addr and the number of
+// words it takes, data statements stop short of labels, code and end
+func (d *disassembler) statement(addr uint16, end int) (string, int) {
+	if ins, ok := d.code[addr]; ok {
+		operands := ins.operands
+		if ins.target != -1 {
+			operands = append(operands, d.operand(addr, ins.target))
+		}
+		return strings.TrimSpace(ins.mnemonic + " " + strings.Join(operands, ", ")), 1
+	}
+
+	if isVector(addr) {
+		if labels := d.labels[d.words[addr]]; len(labels) != 0 {
+			return ".FILL " + labels[0], 1
+		}
+	}
+
+	// data runs on up to the next labelled address or instruction
+	n := 1
+	for int(addr)+n < end && len(d.labels[addr+uint16(n)]) == 0 && d.code[addr+uint16(n)] == nil {
+		n++
+	}
+	if s, size, ok := d.stringz(addr, n); ok {
+		return s, size
+	}
+	if zeros := d.zeros(addr, n); zeros > 1 {
+		return fmt.Sprintf(".BLKW %d", zeros), zeros
+	}
+
+	return fmt.Sprintf(".FILL x%04X", d.words[addr]), 1
+}
+
+// operand returns the label of a target or the offset to it when the target
+// is outside of the image
+func (d *disassembler) operand(addr uint16, target int) string {
+	if labels := d.labels[uint16(target)]; len(labels) != 0 {
+		return labels[0]
+	}
+
+	return fmt.Sprintf("#%d", int16(uint16(target)-addr-1))
+}
+
+// stringz returns a .STRINGZ directive for at least two printable
+// characters followed by a NUL within the first n words at addr
+func (d *disassembler) stringz(addr uint16, n int) (string, int, bool) {
+	var s strings.Builder
+	for i := 0; i < n; i++ {
+		w := d.words[addr+uint16(i)]
+		switch {
+		case w == 0 && i >= 2:
+			return fmt.Sprintf(".STRINGZ \"%s\"", s.String()), i + 1, true
+		case w < ' ' || w > '~' || w == '"' || w == '\\':
+			return "", 0, false
+		}
+		s.WriteByte(byte(w))
+	}
+
+	return "", 0, false
+}
+
+// zeros returns the number of zero words at addr, up to n
+func (d *disassembler) zeros(addr uint16, n int) int {
+	i := 0
+	for i < n && d.words[addr+uint16(i)] == 0 {
+		i++
+	}
+
+	return i
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,164 @@
+package disasm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/asm"
+)
+
+const testSrc = `        .ORIG x3000
+START   LEA R0, MSG
+        PUTS
+        AND R1, R1, #0
+        ADD R1, R1, #3
+LOOP    JSR TICK
+        ADD R1, R1, #-1
+        BRp LOOP
+        LDI R2, PTR
+        HALT
+TICK    ST R7, SAVE
+        LDR R3, R6, #-2
+        NOT R3, R3
+        JSRR R3
+        LD R7, SAVE
+        RET
+PTR     .FILL x4000
+SAVE    .BLKW 3
+MSG     .STRINGZ "hello"
+        .FILL x1234
+        .END
+`
+
+func assemble(t *testing.T, src string) *asm.Image {
+	t.Helper()
+
+	img, err := asm.Assemble(strings.NewReader(src))
+	require.NoError(t, err)
+
+	return img
+}
+
+// segs returns the segments of the image as the bytes of a seg file
+func segs(t *testing.T, img *asm.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, asm.WriteSeg(&buf, img))
+
+	return buf.Bytes()
+}
+
+func TestDisassemble(t *testing.T) {
+	img := assemble(t, testSrc)
+
+	src, err := Disassemble(img)
+	require.NoError(t, err)
+	assert.Equal(t, `        .ORIG       x3000
+        LEA         R0, L3013   ; x3000
+        PUTS                    ; x3001
+        AND         R1, R1, #0  ; x3002
+        ADD         R1, R1, #3  ; x3003
+L3004   JSR         L3009       ; x3004
+        ADD         R1, R1, #-1 ; x3005
+        BRp         L3004       ; x3006
+        LDI         R2, L300F   ; x3007
+        HALT                    ; x3008
+L3009   ST          R7, L3010   ; x3009
+        LDR         R3, R6, #-2 ; x300A
+        NOT         R3, R3      ; x300B
+        JSRR        R3          ; x300C
+        LD          R7, L3010   ; x300D
+        RET                     ; x300E
+L300F   .FILL       x4000       ; x300F
+L3010   .BLKW       3           ; x3010
+L3013   .STRINGZ    "hello"     ; x3013
+        .FILL       x1234       ; x3019
+        .END
+`, string(src))
+	assert.Equal(t, segs(t, img), segs(t, assemble(t, string(src))))
+}
+
+func TestDisassemble_Symbols(t *testing.T) {
+	img := assemble(t, testSrc)
+
+	src, err := Disassemble(img, WithSymbols(asm.SymbolTable{
+		"START": 0x3000, "MSG": 0x3013, "LOOP": 0x3004, "AGAIN": 0x3004, "KBSR": 0xFE00,
+	}))
+	require.NoError(t, err)
+	assert.Contains(t, string(src), "START   LEA         R0, MSG")
+	assert.Contains(t, string(src), "LOOP\nAGAIN   JSR         L3009")
+	assert.Contains(t, string(src), "BRp         AGAIN")
+	assert.NotContains(t, string(src), "KBSR")
+	assert.Equal(t, segs(t, img), segs(t, assemble(t, string(src))))
+}
+
+func TestDisassemble_DebugInfo(t *testing.T) {
+	img := assemble(t, ".ORIG x3000\nBRnzp 1f\n.FILL x1234\n1: HALT\n.END\n")
+
+	src, err := Disassemble(img)
+	require.NoError(t, err)
+	assert.Contains(t, string(src), ".FILL   x1234")
+
+	// code no jump leads to is data unless debug info tells otherwise
+	img = assemble(t, ".ORIG x3000\nHALT\nADD R0, R0, #1\n.END\n")
+	src, err = Disassemble(img)
+	require.NoError(t, err)
+	assert.Contains(t, string(src), ".FILL   x1021")
+
+	src, err = Disassemble(img, WithDebugInfo(asm.NewDebugInfo(img)))
+	require.NoError(t, err)
+	assert.Contains(t, string(src), "ADD     R0, R0, #1")
+	assert.Equal(t, segs(t, img), segs(t, assemble(t, string(src))))
+}
+
+func TestDisassemble_OutsideTargets(t *testing.T) {
+	img := &asm.Image{Segments: []asm.Segment{{Origin: 0x3000, Words: []uint16{
+		0x2005, // LD R0, #5
+		0x0E02, // BRnzp #2
+		0xD000, // reserved opcode
+	}}}}
+
+	src, err := Disassemble(img)
+	require.NoError(t, err)
+	assert.Equal(t, `        .ORIG   x3000
+        LD      R0, #5  ; x3000
+        BRnzp   #2      ; x3001
+        .FILL   xD000   ; x3002
+        .END
+`, string(src))
+	assert.Equal(t, segs(t, img), segs(t, assemble(t, string(src))))
+}
+
+func TestDisassemble_Vectors(t *testing.T) {
+	img := assemble(t, `.ORIG x0020
+        .FILL GETC_ROUTINE
+        .FILL x0205
+        .END
+        .ORIG x0200
+GETC_ROUTINE
+        LDI R0, KBDR
+        RET
+KBDR    .FILL xFE02
+        .END
+`)
+
+	src, err := Disassemble(img)
+	require.NoError(t, err)
+	assert.Equal(t, `        .ORIG   x0020
+        .FILL   L0200   ; x0020
+        .FILL   x0205   ; x0021
+        .END
+
+        .ORIG   x0200
+L0200   LDI     R0, L0202   ; x0200
+        RET                 ; x0201
+L0202   .FILL   xFE02       ; x0202
+        .END
+`, string(src))
+	assert.Equal(t, segs(t, img), segs(t, assemble(t, string(src))))
+}
@@ -0,0 +1,29 @@
+package format
+
+import (
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Diff returns a unified diff turning the source into the formatted one,
+// it is empty when they are equal
+func Diff(filename string, src, formatted []byte) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        lines(src),
+		B:        lines(formatted),
+		FromFile: filename,
+		ToFile:   filename + " (formatted)",
+		Context:  3,
+	})
+}
+
+// lines splits text into lines keeping their line breaks
+func lines(text []byte) []string {
+	lines := strings.SplitAfter(string(text), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
@@ -0,0 +1,301 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+// Option configures Source
+type Option func(c *config)
+
+type config struct {
+	parser []parser.Option
+}
+
+// WithPseudoOps formats pseudo-instructions as instructions, see
+// parser.WithPseudoOps
+func WithPseudoOps() Option {
+	return func(c *config) {
+		c.parser = append(c.parser, parser.WithPseudoOps())
+	}
+}
+
+// WithJMPT formats JMPT as an instruction, see parser.WithJMPT
+func WithJMPT() Option {
+	return func(c *config) {
+		c.parser = append(c.parser, parser.WithJMPT())
+	}
+}
+
+// line is a source line split into columns
+type line struct {
+	labels   string
+	mnemonic string
+	operands string
+	comment  string
+	// indented comment-only lines are aligned with mnemonics, the other
+	// ones stay at the start of the line
+	indented bool
+	blank    bool
+}
+
+func (l *line) code() bool {
+	return l.labels != "" || l.mnemonic != "" || l.operands != ""
+}
+
+// Source formats an LC-3 assembly source. Every line keeps its labels,
+// instruction, operands and comment, these are aligned into columns:
+// labels start lines, mnemonics and operands line up across the file and
+// trailing comments line up within runs of lines. Mnemonics, directives and
+// registers are upper-cased, condition codes of BR are lower-cased. Runs of
+// blank lines are squeezed into one.
+//
+// Source is not preprocessed, so a macro invocation is recognised by the
+// name of a macro defined in the same file or by the operands following
+// it.
+func Source(filename string, src []byte, opts ...Option) ([]byte, error) {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	f := formatter{config: c, macros: map[string]bool{}}
+	var lines []line
+	for _, l := range parser.SplitLines(filename, src) {
+		fl, err := f.line(l)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fl)
+	}
+
+	return render(squeeze(lines)), nil
+}
+
+type formatter struct {
+	config
+	// macros holds lower-cased names of macros defined so far
+	macros map[string]bool
+}
+
+var symbols = parser.Symbols()
+
+func isType(tok lexer.Token, names ...string) bool {
+	for _, name := range names {
+		if tok.Type == symbols[name] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *formatter) line(l parser.Line) (line, error) {
+	tokens, err := parser.Lex(l, f.parser...)
+	if err != nil {
+		return line{}, err
+	}
+	if len(tokens) == 0 {
+		return line{blank: true}, nil
+	}
+
+	var fl line
+	if last := tokens[len(tokens)-1]; isType(last, "Comment") {
+		fl.comment = strings.TrimRight(last.Value, " \t")
+		fl.indented = strings.TrimLeft(l.Text, " \t") != l.Text
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	// leading labels end with a mnemonic, a known macro or a colon
+	var labels []string
+	i := 0
+	for ; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case isType(tok, "Label") && !f.macros[strings.ToLower(tok.Value)]:
+			labels = append(labels, tok.Value)
+			continue
+		case isType(tok, "Number") && i+1 < len(tokens) && isType(tokens[i+1], "Colon"):
+			labels = append(labels, tok.Value)
+			continue
+		case isType(tok, "Colon") && len(labels) != 0 && !strings.HasSuffix(labels[len(labels)-1], ":"):
+			labels[len(labels)-1] += ":"
+			continue
+		}
+		break
+	}
+
+	rest := tokens[i:]
+	switch {
+	case len(rest) != 0 && isType(rest[0], "OpCode", "Trap", "Directive"):
+		fl.mnemonic = mnemonic(rest[0])
+		rest = rest[1:]
+	case len(rest) != 0 && f.macros[strings.ToLower(rest[0].Value)]:
+		fl.mnemonic = rest[0].Value
+		rest = rest[1:]
+	case len(rest) != 0 && len(labels) != 0 && !strings.HasSuffix(labels[len(labels)-1], ":"):
+		// operands after a plain word make it an invocation of a macro
+		// defined elsewhere
+		fl.mnemonic = labels[len(labels)-1]
+		labels = labels[:len(labels)-1]
+	}
+	fl.labels = strings.Join(labels, " ")
+	fl.operands = operands(rest)
+
+	if strings.EqualFold(fl.mnemonic, ".macro") && len(rest) != 0 {
+		f.macros[strings.ToLower(rest[0].Value)] = true
+	}
+
+	return fl, nil
+}
+
+func mnemonic(tok lexer.Token) string {
+	name := strings.ToUpper(tok.Value)
+	if isType(tok, "OpCode") && strings.HasPrefix(name, "BR") {
+		return "BR" + strings.ToLower(name[2:])
+	}
+
+	return name
+}
+
+// operands joins operand tokens with a space after commas and between
+// adjacent words, operators stick to their operands
+func operands(tokens []lexer.Token) string {
+	var b strings.Builder
+	for i, tok := range tokens {
+		if i > 0 && word(tokens[i-1], ")") && word(tok, "(") {
+			b.WriteByte(' ')
+		}
+		switch {
+		case isType(tok, "Comma"):
+			b.WriteString(", ")
+			continue
+		case isType(tok, "Register"):
+			b.WriteString(strings.ToUpper(tok.Value))
+		case isType(tok, "OpCode", "Trap", "Directive"):
+			b.WriteString(mnemonic(tok))
+		default:
+			b.WriteString(tok.Value)
+		}
+	}
+
+	return strings.TrimRight(b.String(), " ")
+}
+
+// word reports whether tok is a value rather than punctuation, paren is the
+// parenthesis counted as one
+func word(tok lexer.Token, paren string) bool {
+	return !isType(tok, "Comma", "Colon", "Operator") || tok.Value == paren
+}
+
+// squeeze drops blank lines at the start and the end and squeezes runs of
+// them into one
+func squeeze(lines []line) []line {
+	var out []line
+	for _, l := range lines {
+		if l.blank && (len(out) == 0 || out[len(out)-1].blank) {
+			continue
+		}
+		out = append(out, l)
+	}
+	for len(out) != 0 && out[len(out)-1].blank {
+		out = out[:len(out)-1]
+	}
+
+	return out
+}
+
+const tabWidth = 4
+
+// column returns the column following the widest of the fields, rounded up
+// to a tab stop and no narrower than min
+func column(width, min int) int {
+	col := (width/tabWidth + 1) * tabWidth
+	if col < min {
+		return min
+	}
+
+	return col
+}
+
+func render(lines []line) []byte {
+	labelWidth, mnemonicWidth := 0, 0
+	for _, l := range lines {
+		if len(l.labels) > labelWidth {
+			labelWidth = len(l.labels)
+		}
+		if len(l.mnemonic) > mnemonicWidth {
+			mnemonicWidth = len(l.mnemonic)
+		}
+	}
+	opCol := column(labelWidth, 8)
+	argCol := opCol + column(mnemonicWidth, 8)
+
+	codes := make([]string, len(lines))
+	for i, l := range lines {
+		var b strings.Builder
+		b.WriteString(l.labels)
+		if l.mnemonic != "" {
+			pad(&b, opCol)
+			b.WriteString(l.mnemonic)
+		}
+		if l.operands != "" {
+			pad(&b, argCol)
+			b.WriteString(l.operands)
+		}
+		codes[i] = b.String()
+	}
+
+	var out bytes.Buffer
+	for start := 0; start < len(lines); {
+		// trailing comments line up within a run of code lines
+		end, width := start, 0
+		for end < len(lines) && lines[end].code() {
+			if lines[end].comment != "" && len(codes[end]) > width {
+				width = len(codes[end])
+			}
+			end++
+		}
+		if end == start {
+			end++
+		}
+		commentCol := column(width, 0)
+
+		for i := start; i < end; i++ {
+			l := lines[i]
+			var b strings.Builder
+			b.WriteString(codes[i])
+			switch {
+			case l.comment != "" && l.code():
+				pad(&b, commentCol)
+				b.WriteString(l.comment)
+			case l.comment != "" && l.indented:
+				pad(&b, opCol)
+				b.WriteString(l.comment)
+			case l.comment != "":
+				b.WriteString(l.comment)
+			}
+			out.WriteString(b.String())
+			out.WriteByte('\n')
+		}
+		start = end
+	}
+
+	return out.Bytes()
+}
+
+// pad fills b with spaces up to col, at least one space separates columns
+func pad(b *strings.Builder, col int) {
+	if b.Len() == 0 && col == 0 {
+		return
+	}
+	n := col - b.Len()
+	if b.Len() != 0 && n < 1 {
+		n = 1
+	}
+	b.WriteString(strings.Repeat(" ", n))
+}
@@ -0,0 +1,88 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSource(t *testing.T) {
+	src := `
+
+; header comment
+   .orig x3000
+main lea r0,MSG   ; load the message
+  puts
+    ; indented comment
+loop: add r1 , r1 , #-1 ;count down
+  brzp loop
+1:  ld r2 , TABLE + ( END-START ) * 2
+    jsr 1b
+
+
+  halt
+MSG .stringz "a ; b"
+	.end
+`
+	want := `; header comment
+        .ORIG       x3000
+main    LEA         R0, MSG ; load the message
+        PUTS
+        ; indented comment
+loop:   ADD         R1, R1, #-1 ;count down
+        BRzp        loop
+1:      LD          R2, TABLE+(END-START)*2
+        JSR         1b
+
+        HALT
+MSG     .STRINGZ    "a ; b"
+        .END
+`
+	out, err := Source("test.asm", []byte(src))
+	require.NoError(t, err)
+	assert.Equal(t, want, string(out))
+
+	again, err := Source("test.asm", out)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(again))
+}
+
+func TestSource_Macros(t *testing.T) {
+	src := `.MACRO push reg
+str reg, r6, #0
+.ENDM
+top push r1
+print_int r0 ; defined elsewhere
+`
+	want := `        .MACRO      push reg
+        STR         reg, R6, #0
+        .ENDM
+top     push        R1
+        print_int   R0  ; defined elsewhere
+`
+	out, err := Source("test.asm", []byte(src))
+	require.NoError(t, err)
+	assert.Equal(t, want, string(out))
+}
+
+func TestSource_PseudoOps(t *testing.T) {
+	out, err := Source("test.asm", []byte("mov r0,r1\n"), WithPseudoOps())
+	require.NoError(t, err)
+	assert.Equal(t, "        MOV     R0, R1\n", string(out))
+}
+
+func TestSource_Error(t *testing.T) {
+	_, err := Source("test.asm", []byte(".STRINGZ \"open\n"))
+	assert.Error(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	d, err := Diff("test.asm", []byte("halt\n"), []byte("        HALT\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "--- test.asm\n+++ test.asm (formatted)\n@@ -1 +1 @@\n-halt\n+        HALT\n", d)
+
+	d, err = Diff("test.asm", []byte("HALT\n"), []byte("HALT\n"))
+	require.NoError(t, err)
+	assert.Empty(t, d)
+}
@@ -0,0 +1,160 @@
+package link
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/asm"
+)
+
+// Archive bundles relocatable modules into a library, Index maps every
+// global symbol to the member defining it so the linker looks symbols up
+// without scanning members
+type Archive struct {
+	Name    string
+	Index   map[string]int
+	Members []Object
+}
+
+// NewArchive builds an archive of objs, global symbols must be unique
+// among them
+func NewArchive(name string, objs []Object) (*Archive, error) {
+	ar := Archive{Name: name, Index: map[string]int{}, Members: objs}
+
+	var errs ErrorList
+	for i, obj := range objs {
+		for _, sym := range obj.Globals {
+			if prev, ok := ar.Index[sym]; ok {
+				errs = append(errs, errorf(obj.Name, "duplicate global symbol '%s', also defined in %s",
+					sym, objs[prev].Name))
+				continue
+			}
+			ar.Index[sym] = i
+		}
+	}
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return &ar, nil
+}
+
+// lookup returns the member defining a global symbol, it is named after the
+// archive and the member as lib.a(print.o)
+func (ar *Archive) lookup(sym string) (Object, int, bool) {
+	i, ok := ar.Index[sym]
+	if !ok {
+		return Object{}, 0, false
+	}
+	obj := ar.Members[i]
+	obj.Name = fmt.Sprintf("%s(%s)", ar.Name, obj.Name)
+
+	return obj, i, true
+}
+
+type archiveMember struct {
+	Name   string
+	Object json.RawMessage
+}
+
+type archiveFile struct {
+	Index   map[string]int
+	Members []archiveMember
+}
+
+// WriteArchive writes the archive as JSON holding the symbol index and
+// members in the format of asm.WriteObject
+func WriteArchive(w io.Writer, ar *Archive) error {
+	f := archiveFile{Index: ar.Index}
+	for _, obj := range ar.Members {
+		var buf bytes.Buffer
+		if err := asm.WriteObject(&buf, obj.Image); err != nil {
+			return err
+		}
+		f.Members = append(f.Members, archiveMember{Name: obj.Name, Object: buf.Bytes()})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(f)
+}
+
+func ReadArchive(r io.Reader, name string) (*Archive, error) {
+	var f archiveFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("malformed archive: %w", err)
+	}
+
+	ar := Archive{Name: name, Index: f.Index}
+	for _, m := range f.Members {
+		img, err := asm.ReadObject(bytes.NewReader(m.Object))
+		if err != nil {
+			return nil, fmt.Errorf("member %s: %w", m.Name, err)
+		}
+		ar.Members = append(ar.Members, Object{Name: m.Name, Image: img})
+	}
+	for sym, i := range ar.Index {
+		if i < 0 || i >= len(ar.Members) {
+			return nil, fmt.Errorf("malformed archive: symbol '%s' refers to member %d of %d", sym, i, len(ar.Members))
+		}
+	}
+
+	return &ar, nil
+}
+
+// pullMembers appends members of the archives that define symbols the
+// modules leave undefined, members pulled in may need more of them. Archives
+// are searched in the order given.
+func (l *linker) pullMembers() {
+	defined := map[string]bool{}
+	pulled := make([]map[int]bool, len(l.archives))
+	for i := range pulled {
+		pulled[i] = map[int]bool{}
+	}
+
+	// the caller's slice is left intact
+	l.objs = append([]Object(nil), l.objs...)
+	for _, obj := range l.objs {
+		for _, sym := range obj.Globals {
+			defined[sym] = true
+		}
+	}
+	for next := 0; next < len(l.objs); next++ {
+		for _, sym := range undefinedSymbols(l.objs[next]) {
+			if defined[sym] {
+				continue
+			}
+			for i, ar := range l.archives {
+				obj, m, ok := ar.lookup(sym)
+				if !ok || pulled[i][m] {
+					continue
+				}
+				pulled[i][m] = true
+				l.objs = append(l.objs, obj)
+				for _, g := range obj.Globals {
+					defined[g] = true
+				}
+				break
+			}
+		}
+	}
+}
+
+// undefinedSymbols returns external symbols a module refers to
+func undefinedSymbols(obj Object) []string {
+	seen := map[string]bool{}
+	var syms []string
+	for _, r := range obj.Relocations {
+		if r.Symbol != "" && !seen[r.Symbol] {
+			seen[r.Symbol] = true
+			syms = append(syms, r.Symbol)
+		}
+	}
+	sort.Strings(syms)
+
+	return syms
+}
@@ -0,0 +1,87 @@
+package link
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mulSrc = `
+        .SECTION text
+        .GLOBAL MUL
+        .EXTERNAL ADDN
+MUL     JSR ADDN
+        RET
+        .END
+`
+
+const addSrc = `
+        .SECTION text
+        .GLOBAL ADDN
+ADDN    ADD R0, R0, R1
+        RET
+        .END
+`
+
+const divSrc = `
+        .SECTION text
+        .GLOBAL DIV
+DIV     RET
+        .END
+`
+
+const callerSrc = `
+        .ORIG x3000
+        .EXTERNAL MUL
+        JSR MUL
+        HALT
+        .END
+`
+
+func TestArchive(t *testing.T) {
+	ar, err := NewArchive("lib.a", []Object{
+		module(t, "div.o", divSrc),
+		module(t, "mul.o", mulSrc),
+		module(t, "add.o", addSrc),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"DIV": 0, "MUL": 1, "ADDN": 2}, ar.Index)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteArchive(&buf, ar))
+	ar, err = ReadArchive(&buf, "lib.a")
+	require.NoError(t, err)
+	require.Len(t, ar.Members, 3)
+	assert.Equal(t, "mul.o", ar.Members[1].Name)
+
+	objs := []Object{module(t, "main.o", callerSrc)}
+	img, err := Link(objs, WithArchives(ar))
+	require.NoError(t, err)
+	assert.Len(t, objs, 1)
+
+	// mul.o is pulled in by main.o and add.o by mul.o, div.o is left out
+	assert.Equal(t, uint16(0x3002), img.Symbols["MUL"])
+	assert.Equal(t, uint16(0x3004), img.Symbols["ADDN"])
+	assert.NotContains(t, img.Symbols, "DIV")
+	assert.Equal(t, []uint16{0x4801, 0xF025}, img.Segments[0].Words)
+	assert.Equal(t, []uint16{0x4801, 0xC1C0}, img.Segments[1].Words)
+	assert.Equal(t, "add.o", img.SourceMap[len(img.SourceMap)-1].Pos.Filename)
+}
+
+func TestArchive_Errors(t *testing.T) {
+	_, err := NewArchive("lib.a", []Object{module(t, "a.o", divSrc), module(t, "b.o", divSrc)})
+	require.Error(t, err)
+	assert.Equal(t, "b.o: duplicate global symbol 'DIV', also defined in a.o", err.Error())
+
+	_, err = ReadArchive(bytes.NewBufferString(`{"Index": {"X": 1}, "Members": []}`), "lib.a")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "symbol 'X' refers to member 1 of 0")
+
+	ar, err := NewArchive("lib.a", []Object{module(t, "mul.o", mulSrc)})
+	require.NoError(t, err)
+	_, err = Link([]Object{module(t, "main.o", callerSrc)}, WithArchives(ar))
+	require.Error(t, err)
+	assert.Equal(t, "lib.a(mul.o): undefined symbol 'ADDN'", err.Error())
+}
@@ -0,0 +1,163 @@
+package link
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/machine"
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+// Region is a memory range [Start, End] sections are placed into one after
+// another in the order listed, only privileged regions may lie outside of
+// user memory
+type Region struct {
+	Name       string
+	Start, End uint16
+	Privileged bool
+	Sections   []string
+}
+
+// Layout maps sections of relocatable modules to memory regions
+type Layout []Region
+
+// DefaultLayout places the vectors section into the trap and interrupt
+// vector tables, the system section into the rest of privileged memory and
+// text and data sections into user memory
+func DefaultLayout() Layout {
+	return Layout{
+		{Name: "vectors", Start: machine.TrapVecTblStart, End: machine.IntVecTblEnd, Privileged: true,
+			Sections: []string{"vectors"}},
+		{Name: "system", Start: machine.PrivilegedStart, End: machine.PrivilegedEnd, Privileged: true,
+			Sections: []string{"system"}},
+		{Name: "user", Start: machine.UserStart, End: machine.UserEnd,
+			Sections: []string{"text", "data"}},
+	}
+}
+
+// ParseLayout reads a layout file, every line of it describes a region:
+//
+//	# name  range        [privileged]  sections
+//	vectors x0000-x01FF  privileged    vectors
+//	user    x3000-xFDFF                text data
+//
+// Comments start with # or ;, addresses are written as LC-3 numbers.
+func ParseLayout(r io.Reader, filename string) (Layout, error) {
+	var layout Layout
+	var errs ErrorList
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		region, err := parseRegion(fields)
+		if err != nil {
+			errs = append(errs, errorf(fmt.Sprintf("%s:%d", filename, n), "%s", err))
+			continue
+		}
+		layout = append(layout, region)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return layout, nil
+}
+
+func parseRegion(fields []string) (Region, error) {
+	if len(fields) < 2 {
+		return Region{}, fmt.Errorf("region name and address range expected")
+	}
+
+	region := Region{Name: fields[0]}
+	bounds := strings.SplitN(fields[1], "-", 2)
+	if len(bounds) != 2 {
+		return Region{}, fmt.Errorf("address range START-END expected, got '%s'", fields[1])
+	}
+	var err error
+	if region.Start, err = parseAddr(bounds[0]); err != nil {
+		return Region{}, err
+	}
+	if region.End, err = parseAddr(bounds[1]); err != nil {
+		return Region{}, err
+	}
+
+	sections := fields[2:]
+	if len(sections) != 0 && strings.EqualFold(sections[0], "privileged") {
+		region.Privileged = true
+		sections = sections[1:]
+	}
+	for _, name := range sections {
+		if !parser.IsLabel(name) {
+			return Region{}, fmt.Errorf("invalid section name '%s'", name)
+		}
+	}
+	region.Sections = sections
+
+	return region, nil
+}
+
+func parseAddr(s string) (uint16, error) {
+	var n parser.Number
+	if err := n.Capture([]string{s}); err != nil || n < 0 || int(n) > int(machine.MemoryEnd) {
+		return 0, fmt.Errorf("invalid address '%s'", s)
+	}
+
+	return uint16(n), nil
+}
+
+// check reports regions reaching device registers, non-privileged regions
+// outside of user memory, overlapping regions and sections listed twice
+func (layout Layout) check() ErrorList {
+	var errs ErrorList
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, errorf("", format, args...))
+	}
+
+	owners := map[string]string{}
+	for _, r := range layout {
+		switch {
+		case r.End < r.Start:
+			fail("region '%s' x%04X-x%04X ends before it starts", r.Name, r.Start, r.End)
+		case r.End >= machine.DeviceRegStart:
+			fail("region '%s' x%04X-x%04X overlaps device registers x%04X-x%04X",
+				r.Name, r.Start, r.End, machine.DeviceRegStart, machine.DeviceRegEnd)
+		case !r.Privileged && (r.Start < machine.UserStart || r.End > machine.UserEnd):
+			fail("region '%s' x%04X-x%04X is outside of user memory x%04X-x%04X, mark it privileged",
+				r.Name, r.Start, r.End, machine.UserStart, machine.UserEnd)
+		}
+		for _, name := range r.Sections {
+			if owner, ok := owners[name]; ok {
+				fail("section '%s' is placed into both regions '%s' and '%s'", name, owner, r.Name)
+				continue
+			}
+			owners[name] = r.Name
+		}
+	}
+
+	sorted := make(Layout, len(layout))
+	copy(sorted, layout)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	for i := 1; i < len(sorted); i++ {
+		prev, cur := sorted[i-1], sorted[i]
+		if cur.Start <= prev.End {
+			fail("region '%s' x%04X-x%04X overlaps region '%s' x%04X-x%04X",
+				cur.Name, cur.Start, cur.End, prev.Name, prev.Start, prev.End)
+		}
+	}
+
+	return errs
+}
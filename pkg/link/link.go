@@ -0,0 +1,389 @@
+package link
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/asm"
+	"github.com/alexey-medvedchikov/lc3/pkg/machine"
+)
+
+// Object is a relocatable module, Name identifies it in errors
+type Object struct {
+	Name string
+	*asm.Image
+}
+
+type Error struct {
+	Object string
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	if e.Object == "" {
+		return e.Msg
+	}
+
+	return fmt.Sprintf("%s: %s", e.Object, e.Msg)
+}
+
+func errorf(obj string, format string, args ...interface{}) *Error {
+	return &Error{Object: obj, Msg: fmt.Sprintf(format, args...)}
+}
+
+// ErrorList holds every error found while linking
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// global is a symbol exported by a module
+type global struct {
+	addr uint16
+	obj  string
+}
+
+type config struct {
+	layout   Layout
+	archives []*Archive
+}
+
+type Option func(c *config)
+
+// WithLayout places sections into the regions of layout instead of the
+// DefaultLayout ones
+func WithLayout(layout Layout) Option {
+	return func(c *config) {
+		c.layout = layout
+	}
+}
+
+// WithArchives links in members of archives defining symbols left undefined
+// by the modules
+func WithArchives(archives ...*Archive) Option {
+	return func(c *config) {
+		c.archives = append(c.archives, archives...)
+	}
+}
+
+type linker struct {
+	config
+
+	objs []Object
+	// deltas holds the distance every segment of every module is moved by
+	deltas  [][]int
+	globals map[string]global
+	segs    []asm.Segment
+	errs    ErrorList
+}
+
+// sectionRef is a segment of a module belonging to a section
+type sectionRef struct {
+	obj, seg int
+}
+
+// Link places the modules given followed by the archive members they need.
+// Segments started with .ORIG stay at the addresses they were assembled
+// for and must be in user memory, sections are placed into the regions of
+// the layout in the order of modules around them. It resolves external
+// symbols against globals of all modules and patches relocated words.
+func Link(objs []Object, opts ...Option) (*asm.Image, error) {
+	l := linker{config: config{layout: DefaultLayout()}, objs: objs, globals: map[string]global{}}
+	for _, opt := range opts {
+		opt(&l.config)
+	}
+
+	l.errs = append(l.errs, l.layout.check()...)
+	if len(l.errs) != 0 {
+		return nil, l.errs
+	}
+	l.pullMembers()
+	l.place()
+	l.collectGlobals()
+	if len(l.errs) == 0 {
+		l.relocate()
+		l.checkOverlaps()
+	}
+	if len(l.errs) != 0 {
+		return nil, l.errs
+	}
+
+	return &asm.Image{
+		Segments:  l.segs,
+		Symbols:   l.symbols(),
+		SourceMap: l.sourceMap(),
+		Format:    asm.FormatObj,
+	}, nil
+}
+
+func (l *linker) fail(err *Error) {
+	l.errs = append(l.errs, err)
+}
+
+func (l *linker) place() {
+	sections := map[string][]sectionRef{}
+	for i, obj := range l.objs {
+		l.deltas = append(l.deltas, make([]int, len(obj.Segments)))
+		for k, seg := range obj.Segments {
+			if seg.Section != "" {
+				sections[seg.Section] = append(sections[seg.Section], sectionRef{obj: i, seg: k})
+				continue
+			}
+			// code placed with .ORIG is user code, privileged code goes
+			// into sections of privileged regions
+			end := int(seg.Origin) + len(seg.Words) - 1
+			if len(seg.Words) != 0 && (seg.Origin < machine.UserStart || end > int(machine.UserEnd)) {
+				l.fail(errorf(obj.Name, "segment x%04X-x%04X of .ORIG x%04X is outside of user memory x%04X-x%04X, "+
+					"place privileged code with .SECTION", seg.Origin, end, seg.Origin, machine.UserStart, machine.UserEnd))
+			}
+		}
+	}
+	l.placeSections(sections)
+
+	for i, obj := range l.objs {
+		for k, seg := range obj.Segments {
+			words := make([]uint16, len(seg.Words))
+			copy(words, seg.Words)
+			l.segs = append(l.segs, asm.Segment{Origin: uint16(int(seg.Origin) + l.deltas[i][k]), Words: words})
+		}
+	}
+}
+
+// placeSections puts segments of every section one after another into the
+// region of the layout the section belongs to, skipping over segments
+// started with .ORIG
+func (l *linker) placeSections(sections map[string][]sectionRef) {
+	placed := map[string]bool{}
+	for _, r := range l.layout {
+		next, overflow := int(r.Start), ""
+		for _, name := range r.Sections {
+			placed[name] = true
+			for _, ref := range sections[name] {
+				seg := l.objs[ref.obj].Segments[ref.seg]
+				next = l.pastOrigins(next, len(seg.Words))
+				l.deltas[ref.obj][ref.seg] = next - int(seg.Origin)
+				next += len(seg.Words)
+				if next > int(r.End)+1 && overflow == "" {
+					overflow = l.objs[ref.obj].Name
+				}
+			}
+		}
+		if overflow != "" {
+			l.fail(errorf(overflow, "sections of region '%s' x%04X-x%04X overflow it by %d words",
+				r.Name, r.Start, r.End, next-int(r.End)-1))
+		}
+	}
+
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !placed[name] {
+			ref := sections[name][0]
+			l.fail(errorf(l.objs[ref.obj].Name, "section '%s' is not placed into any region of the layout", name))
+		}
+	}
+}
+
+// pastOrigins returns the first address from addr where n words do not
+// overlap segments started with .ORIG
+func (l *linker) pastOrigins(addr, n int) int {
+	for moved := true; moved && n != 0; {
+		moved = false
+		for _, obj := range l.objs {
+			for _, seg := range obj.Segments {
+				start, end := int(seg.Origin), int(seg.Origin)+len(seg.Words)
+				if seg.Section == "" && len(seg.Words) != 0 && addr < end && start < addr+n {
+					addr, moved = end, true
+				}
+			}
+		}
+	}
+
+	return addr
+}
+
+func (l *linker) collectGlobals() {
+	for i, obj := range l.objs {
+		for _, name := range obj.Globals {
+			addr, ok := obj.Symbols[name]
+			if !ok {
+				l.fail(errorf(obj.Name, "global symbol '%s' is not defined", name))
+				continue
+			}
+			delta, ok := l.delta(i, obj.SymbolSegments[name])
+			if !ok {
+				l.fail(errorf(obj.Name, "global symbol '%s' is outside of the module", name))
+				continue
+			}
+			if prev, ok := l.globals[name]; ok {
+				l.fail(errorf(obj.Name, "duplicate global symbol '%s', also defined in %s", name, prev.obj))
+				continue
+			}
+			l.globals[name] = global{addr: uint16(int(addr) + delta), obj: obj.Name}
+		}
+	}
+}
+
+func (l *linker) relocate() {
+	for i, obj := range l.objs {
+		undefined := map[string]bool{}
+		for _, r := range obj.Relocations {
+			delta, ok := l.delta(i, r.Segment)
+			targetDelta, okTarget := l.delta(i, r.Target)
+			var word *uint16
+			addr := uint16(int(r.Addr) + delta)
+			if ok && okTarget {
+				word = l.word(addr)
+			}
+			if word == nil {
+				l.fail(errorf(obj.Name, "relocation at x%04X is outside of the module", r.Addr))
+				continue
+			}
+
+			target := int(*word) + targetDelta
+			if r.Kind != asm.RelocAbs16 {
+				target = r.Addend + targetDelta
+			}
+			if r.Symbol != "" {
+				g, ok := l.globals[r.Symbol]
+				if !ok {
+					if !undefined[r.Symbol] {
+						l.fail(errorf(obj.Name, "undefined symbol '%s'", r.Symbol))
+						undefined[r.Symbol] = true
+					}
+					continue
+				}
+				target = int(g.addr) + r.Addend
+			}
+
+			switch r.Kind {
+			case asm.RelocAbs16:
+				*word = uint16(target)
+			case asm.RelocPC9, asm.RelocPC11:
+				bits := 9
+				if r.Kind == asm.RelocPC11 {
+					bits = 11
+				}
+				offset := target - int(addr+1)
+				if lo, hi := -(1 << (bits - 1)), 1<<(bits-1)-1; offset < lo || offset > hi {
+					l.fail(errorf(obj.Name, "x%04X: %s at x%04X is %d words away from PC x%04X, out of %d-bit offset range [%d, %d]",
+						addr, targetName(r), uint16(target), offset, addr+1, bits, lo, hi))
+					continue
+				}
+				mask := uint16(1)<<bits - 1
+				*word = *word&^mask | uint16(offset)&mask
+			}
+		}
+	}
+}
+
+// delta returns the distance the segment seg of the module obj is moved by
+func (l *linker) delta(obj, seg int) (int, bool) {
+	deltas := l.deltas[obj]
+	switch {
+	case seg >= 0 && seg < len(deltas):
+		return deltas[seg], true
+	case seg == 0:
+		// a module without segments
+		return 0, true
+	}
+
+	return 0, false
+}
+
+func targetName(r asm.Relocation) string {
+	if r.Symbol == "" {
+		return "target"
+	}
+
+	return fmt.Sprintf("target '%s'", r.Symbol)
+}
+
+// word returns the word at addr in the linked segments
+func (l *linker) word(addr uint16) *uint16 {
+	for i := range l.segs {
+		seg := &l.segs[i]
+		if addr >= seg.Origin && int(addr) < int(seg.Origin)+len(seg.Words) {
+			return &seg.Words[addr-seg.Origin]
+		}
+	}
+
+	return nil
+}
+
+func (l *linker) checkOverlaps() {
+	type span struct {
+		start, end int
+		obj        string
+	}
+	var spans []span
+	for i, obj := range l.objs {
+		for k, seg := range obj.Segments {
+			if len(seg.Words) != 0 {
+				start := int(seg.Origin) + l.deltas[i][k]
+				spans = append(spans, span{start: start, end: start + len(seg.Words), obj: obj.Name})
+			}
+		}
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	for i := 1; i < len(spans); i++ {
+		prev, cur := spans[i-1], spans[i]
+		if cur.start < prev.end {
+			l.fail(errorf(cur.obj, "segment x%04X-x%04X overlaps segment x%04X-x%04X of %s",
+				cur.start, cur.end-1, prev.start, prev.end-1, prev.obj))
+		}
+	}
+}
+
+// symbols merges symbol tables of the modules, globals take precedence over
+// local labels and of local labels sharing a name the first one is kept
+func (l *linker) symbols() asm.SymbolTable {
+	symbols := asm.SymbolTable{}
+	for i, obj := range l.objs {
+		for name, addr := range obj.Symbols {
+			if _, ok := symbols[name]; !ok {
+				delta, _ := l.delta(i, obj.SymbolSegments[name])
+				symbols[name] = uint16(int(addr) + delta)
+			}
+		}
+	}
+	for name, g := range l.globals {
+		symbols[name] = g.addr
+	}
+
+	return symbols
+}
+
+// sourceMap moves source entries of the modules to their final addresses
+func (l *linker) sourceMap() []asm.SourceEntry {
+	var entries []asm.SourceEntry
+	for i, obj := range l.objs {
+		for _, e := range obj.SourceMap {
+			delta, _ := l.delta(i, e.Segment)
+			e.Addr = uint16(int(e.Addr) + delta)
+			if n := len(e.Words); n != 0 {
+				words := make([]uint16, n)
+				for k := range words {
+					if w := l.word(e.Addr + uint16(k)); w != nil {
+						words[k] = *w
+					}
+				}
+				e.Words = words
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
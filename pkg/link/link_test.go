@@ -0,0 +1,207 @@
+package link
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/asm"
+)
+
+func module(t *testing.T, name, src string) Object {
+	t.Helper()
+
+	img, err := asm.Assemble(strings.NewReader(src), asm.WithFilename(name), asm.WithRelocatable())
+	require.NoError(t, err)
+
+	return Object{Name: name, Image: img}
+}
+
+const mainSrc = `
+        .ORIG x3000
+        .EXTERNAL PRINT, MSG
+MAIN    LEA R0, MSG
+        JSR PRINT
+        HALT
+PTR     .FILL MSG+1
+        .END
+`
+
+const libSrc = `
+        .SECTION text
+        .GLOBAL PRINT, MSG
+PRINT   PUTS
+        RET
+MSG     .STRINGZ "hi"
+SELF    .FILL SELF
+        .END
+`
+
+func TestLink(t *testing.T) {
+	img, err := Link([]Object{module(t, "main.asm", mainSrc), module(t, "lib.asm", libSrc)})
+	require.NoError(t, err)
+
+	require.Len(t, img.Segments, 2)
+	assert.Equal(t, asm.Segment{Origin: 0x3000, Words: []uint16{0xE005, 0x4802, 0xF025, 0x3007}}, img.Segments[0])
+	assert.Equal(t, asm.Segment{Origin: 0x3004, Words: []uint16{0xF022, 0xC1C0, 'h', 'i', 0, 0x3009}}, img.Segments[1])
+
+	assert.Equal(t, uint16(0x3004), img.Symbols["PRINT"])
+	assert.Equal(t, uint16(0x3009), img.Symbols["SELF"])
+	assert.Equal(t, "lib.asm", img.SourceMap[len(img.SourceMap)-2].Pos.Filename)
+	assert.Equal(t, uint16(0x3009), img.SourceMap[len(img.SourceMap)-2].Addr)
+}
+
+func TestLink_Errors(t *testing.T) {
+	far := `
+        .SECTION text
+        .GLOBAL PRINT, MSG
+        .BLKW 300
+PRINT   RET
+MSG     .FILL 0
+        .END
+`
+	tests := map[string]struct {
+		objs []Object
+		msgs []string
+	}{
+		"undefined": {
+			objs: []Object{module(t, "main.asm", mainSrc)},
+			msgs: []string{"main.asm: undefined symbol 'MSG'", "main.asm: undefined symbol 'PRINT'"},
+		},
+		"duplicate": {
+			objs: []Object{module(t, "main.asm", mainSrc), module(t, "lib.asm", libSrc), module(t, "lib2.asm", libSrc)},
+			msgs: []string{
+				"lib2.asm: duplicate global symbol 'MSG', also defined in lib.asm",
+				"lib2.asm: duplicate global symbol 'PRINT', also defined in lib.asm",
+			},
+		},
+		"privileged origin": {
+			objs: []Object{module(t, "main.asm", mainSrc), module(t, "os.asm", ".ORIG x0200\n.GLOBAL PRINT, MSG\nPRINT RET\nMSG .FILL 0\n.END")},
+			msgs: []string{"os.asm: segment x0200-x0201 of .ORIG x0200 is outside of user memory x3000-xFDFF, place privileged code with .SECTION"},
+		},
+		"same origin": {
+			objs: []Object{module(t, "main.asm", mainSrc), module(t, "lib.asm", strings.Replace(libSrc, ".SECTION text", ".ORIG x3000", 1))},
+			msgs: []string{"lib.asm: segment x3000-x3005 overlaps segment x3000-x3003 of main.asm"},
+		},
+		"range": {
+			objs: []Object{module(t, "main.asm", mainSrc), module(t, "far.asm", far)},
+			msgs: []string{"main.asm: x3000: target 'MSG' at x3131 is 304 words away from PC x3001, out of 9-bit offset range [-256, 255]"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Link(tt.objs)
+			require.Error(t, err)
+
+			var msgs []string
+			for _, e := range err.(ErrorList) {
+				msgs = append(msgs, e.Error())
+			}
+			assert.Equal(t, tt.msgs, msgs)
+		})
+	}
+}
+
+const textSrc = `
+        .SECTION text
+MAIN    LEA R0, MSG
+        PUTS
+        HALT
+        .SECTION data
+MSG     .STRINGZ "hi"
+PTR     .FILL MAIN
+        .END
+`
+
+const sysSrc = `
+        .SECTION vectors
+        .BLKW x20
+        .FILL PRINT
+        .SECTION system
+        .GLOBAL PRINT
+PRINT   PUTS
+        RET
+        .END
+`
+
+func TestLink_Sections(t *testing.T) {
+	img, err := Link([]Object{module(t, "main.asm", textSrc), module(t, "sys.asm", sysSrc)})
+	require.NoError(t, err)
+
+	require.Len(t, img.Segments, 4)
+	assert.Equal(t, asm.Segment{Origin: 0x3000, Words: []uint16{0xE002, 0xF022, 0xF025}}, img.Segments[0])
+	assert.Equal(t, asm.Segment{Origin: 0x3003, Words: []uint16{'h', 'i', 0, 0x3000}}, img.Segments[1])
+	assert.Equal(t, uint16(0x0200), img.Segments[2].Words[0x20])
+	assert.Equal(t, asm.Segment{Origin: 0x0200, Words: []uint16{0xF022, 0xC1C0}}, img.Segments[3])
+
+	assert.Equal(t, uint16(0x3003), img.Symbols["MSG"])
+	assert.Equal(t, uint16(0x0200), img.Symbols["PRINT"])
+}
+
+func TestLink_Layout(t *testing.T) {
+	layout, err := ParseLayout(strings.NewReader(`
+# OS image
+vectors x0000-x01FF privileged vectors
+kernel  x0200-x0203 PRIVILEGED text data ; too small
+`), "os.ld")
+	require.NoError(t, err)
+	assert.Equal(t, Layout{
+		{Name: "vectors", Start: 0x0000, End: 0x01FF, Privileged: true, Sections: []string{"vectors"}},
+		{Name: "kernel", Start: 0x0200, End: 0x0203, Privileged: true, Sections: []string{"text", "data"}},
+	}, layout)
+
+	_, err = Link([]Object{module(t, "main.asm", textSrc)}, WithLayout(layout))
+	require.Error(t, err)
+	assert.Equal(t, "main.asm: sections of region 'kernel' x0200-x0203 overflow it by 3 words", err.Error())
+}
+
+func TestLink_LayoutErrors(t *testing.T) {
+	tests := map[string]struct {
+		layout string
+		msg    string
+	}{
+		"range": {
+			layout: "user x3000 text",
+			msg:    "os.ld:1: address range START-END expected, got 'x3000'",
+		},
+		"address": {
+			layout: "user x3000-x10000 text",
+			msg:    "os.ld:1: invalid address 'x10000'",
+		},
+		"user code in privileged memory": {
+			layout: "user x2000-x3FFF text data\nsystem x0200-x1FFF privileged system vectors",
+			msg:    "region 'user' x2000-x3FFF is outside of user memory x3000-xFDFF, mark it privileged",
+		},
+		"overlap": {
+			layout: "user x3000-x3FFF text\nmore x3800-x4FFF data\nsystem x0000-x2FFF privileged system vectors",
+			msg:    "region 'more' x3800-x4FFF overlaps region 'user' x3000-x3FFF",
+		},
+		"devices": {
+			layout: "user x3000-xFDFF text\nio xFE00-xFFFF privileged data\nsystem x0000-x2FFF privileged system vectors",
+			msg:    "region 'io' xFE00-xFFFF overlaps device registers xFE00-xFFFF",
+		},
+		"twice": {
+			layout: "user x3000-x3FFF text data\nmore x4000-x4FFF data\nsystem x0000-x2FFF privileged system vectors",
+			msg:    "section 'data' is placed into both regions 'user' and 'more'",
+		},
+		"unplaced": {
+			layout: "user x3000-x3FFF text data",
+			msg:    "sys.asm: section 'system' is not placed into any region of the layout",
+		},
+	}
+
+	objs := []Object{module(t, "main.asm", textSrc), module(t, "sys.asm", sysSrc)}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			layout, err := ParseLayout(strings.NewReader(tt.layout), "os.ld")
+			if err == nil {
+				_, err = Link(objs, WithLayout(layout))
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.msg)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+// Warning is a likely mistake found in a program, Rule is the ID of the rule
+// that found it
+type Warning struct {
+	Pos  lexer.Position
+	Rule string
+	Msg  string
+}
+
+func (w *Warning) Error() string {
+	return fmt.Sprintf("%s: %s [%s]", w.Pos, w.Msg, w.Rule)
+}
+
+// Position and Message make warnings look like parser errors
+func (w *Warning) Position() lexer.Position { return w.Pos }
+func (w *Warning) Message() string          { return fmt.Sprintf("%s [%s]", w.Msg, w.Rule) }
+
+type rule struct {
+	id    string
+	doc   string
+	check func(l *linter)
+}
+
+var rules = []rule{
+	{id: "unused-label", doc: "a label is never referred to", check: checkUnusedLabels},
+	{id: "unreachable", doc: "an instruction follows BRnzp, JMP, RET or HALT without a label", check: checkUnreachable},
+	{id: "br-no-flags", doc: "BR is written without condition flags and always branches", check: checkBareBR},
+	{id: "no-halt", doc: "execution runs past the last instruction of a segment", check: checkNoHalt},
+	{id: "nested-jsr", doc: "a subroutine calls another one before saving R7", check: checkNestedJSR},
+	{id: "uninit-read", doc: ".BLKW storage is read before anything is written to it", check: checkUninitRead},
+}
+
+// Rules maps IDs of the rules to their descriptions
+func Rules() map[string]string {
+	docs := map[string]string{}
+	for _, r := range rules {
+		docs[r.id] = r.doc
+	}
+
+	return docs
+}
+
+// Option configures Check
+type Option func(l *linter)
+
+// WithDisabled turns rules off for the whole program
+func WithDisabled(ids ...string) Option {
+	return func(l *linter) {
+		for _, id := range ids {
+			l.disabled[id] = true
+		}
+	}
+}
+
+type linter struct {
+	prog     *parser.Program
+	disabled map[string]bool
+	// ignored holds rules a "; lint:ignore RULE" comment turns off for the
+	// statement it is on, a comment on a line of its own applies to the
+	// next statement
+	ignored  map[int]map[string]bool
+	warnings []*Warning
+}
+
+// Check runs every rule over a parsed program and returns the warnings in
+// source order. A warning is suppressed with a "; lint:ignore RULE ..."
+// comment on the statement it is reported for or on the line above it.
+func Check(prog *parser.Program, opts ...Option) []*Warning {
+	l := linter{prog: prog, disabled: map[string]bool{}, ignored: map[int]map[string]bool{}}
+	for _, opt := range opts {
+		opt(&l)
+	}
+	l.collectIgnores()
+
+	for _, r := range rules {
+		if !l.disabled[r.id] {
+			r.check(&l)
+		}
+	}
+	sort.SliceStable(l.warnings, func(i, j int) bool {
+		a, b := l.warnings[i].Pos, l.warnings[j].Pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Offset < b.Offset
+	})
+
+	return l.warnings
+}
+
+// IsRule reports whether id names a rule
+func IsRule(id string) bool {
+	_, ok := Rules()[id]
+
+	return ok
+}
+
+const ignoreMark = "lint:ignore"
+
+func (l *linter) collectIgnores() {
+	var pending map[string]bool
+	for i, st := range l.prog.Statements {
+		ids := ignoreComment(st.Comment)
+		if isCommentOnly(st) {
+			pending = ids
+			continue
+		}
+		if ids == nil {
+			ids = map[string]bool{}
+		}
+		for id := range pending {
+			ids[id] = true
+		}
+		pending = nil
+		if len(ids) != 0 {
+			l.ignored[i] = ids
+		}
+	}
+}
+
+func ignoreComment(c *parser.Comment) map[string]bool {
+	if c == nil || c.Comment == nil {
+		return nil
+	}
+	text := strings.TrimLeft(*c.Comment, "; \t")
+	if !strings.HasPrefix(text, ignoreMark) {
+		return nil
+	}
+
+	ids := map[string]bool{}
+	for _, id := range strings.FieldsFunc(text[len(ignoreMark):], func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	}) {
+		ids[id] = true
+	}
+
+	return ids
+}
+
+func isCommentOnly(st *parser.Statement) bool {
+	return st.Comment != nil && len(st.Labels) == 0 && st.Directive == nil && st.Op == nil && st.Trap == nil
+}
+
+// warn reports a warning of rule for the statement with index stmt
+func (l *linter) warn(stmt int, pos lexer.Position, rule string, format string, args ...interface{}) {
+	if l.ignored[stmt][rule] {
+		return
+	}
+	l.warnings = append(l.warnings, &Warning{Pos: pos, Rule: rule, Msg: fmt.Sprintf(format, args...)})
+}
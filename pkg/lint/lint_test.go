@@ -0,0 +1,130 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+func check(t *testing.T, src string, opts ...Option) []string {
+	t.Helper()
+
+	prog, err := parser.ParseBytes("test.asm", []byte(src))
+	require.NoError(t, err)
+
+	var msgs []string
+	for _, w := range Check(prog, opts...) {
+		msgs = append(msgs, w.Error())
+	}
+
+	return msgs
+}
+
+func TestCheck(t *testing.T) {
+	tests := map[string]struct {
+		src  string
+		msgs []string
+	}{
+		"clean": {
+			src: `
+        .ORIG x3000
+        LEA R0, MSG
+        JSR PRINT
+        HALT
+PRINT   ST R7, SAVE
+        PUTS
+        JSR 1f
+        LD R7, SAVE
+1:      RET
+SAVE    .BLKW 1
+MSG     .STRINGZ "hi"
+        .END
+`,
+		},
+		"unused label": {
+			src: ".ORIG x3000\nSTART HALT\n1: .FILL 0\n.END",
+			msgs: []string{
+				"test.asm:2:1: label 'START' is never used [unused-label]",
+				"test.asm:3:1: local label '1' is never used [unused-label]",
+			},
+		},
+		"unreachable": {
+			src:  ".ORIG x3000\nBRnzp 1f\n.FILL 0\nADD R0, R0, #1\nADD R0, R0, #1\n1: HALT\nRET\n.END",
+			msgs: []string{"test.asm:4:1: instruction is never executed, it follows BRNZP and has no label [unreachable]", "test.asm:7:1: instruction is never executed, it follows HALT and has no label [unreachable]"},
+		},
+		"bare BR": {
+			src:  ".ORIG x3000\n1: BR 1b\n.END",
+			msgs: []string{"test.asm:2:4: BR without condition flags is assembled as BRnzp, write BRnzp or the flags meant [br-no-flags]"},
+		},
+		"no halt": {
+			src:  ".ORIG x3000\nADD R0, R0, #1\n.FILL 0\n.END",
+			msgs: []string{"test.asm:2:1: execution runs past the last instruction ADD of the segment, HALT expected [no-halt]"},
+		},
+		"halt by number": {
+			src: ".ORIG x3000\nTRAP x25\n.END",
+		},
+		"nested JSR": {
+			src: `.ORIG x3000
+JSR OUTER
+HALT
+OUTER ADD R1, R1, #1
+JSR INNER
+JSR INNER
+RET
+INNER RET
+.END`,
+			msgs: []string{"test.asm:5:1: JSR within subroutine OUTER overwrites R7 before it is saved, OUTER can not return [nested-jsr]"},
+		},
+		"saved with ADD": {
+			src: ".ORIG x3000\nJSR OUTER\nHALT\nOUTER ADD R6, R7, #0\nJSR INNER\nJMP R6\nINNER RET\n.END",
+		},
+		"uninitialized read": {
+			src: `.ORIG x3000
+LD R0, A
+ST R0, B
+LD R0, B
+LDI R0, P
+LEA R1, Q
+LD R0, Q
+HALT
+A .BLKW 1
+B .BLKW 1
+P .BLKW 1
+Q .BLKW 1
+C .BLKW 1, 0
+.FILL C
+.END`,
+			msgs: []string{
+				"test.asm:2:8: 'A' is read before anything is stored to it [uninit-read]",
+				"test.asm:5:9: 'P' is read before anything is stored to it [uninit-read]",
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.msgs, check(t, tt.src))
+		})
+	}
+}
+
+func TestCheck_Ignore(t *testing.T) {
+	src := `.ORIG x3000
+START HALT ; lint:ignore unused-label
+; lint:ignore unused-label, unreachable
+UNUSED HALT
+HALT
+.END`
+	assert.Equal(t, []string{"test.asm:5:1: instruction is never executed, it follows HALT and has no label [unreachable]"}, check(t, src))
+
+	assert.Empty(t, check(t, src, WithDisabled("unreachable")))
+}
+
+func TestRules(t *testing.T) {
+	assert.Len(t, Rules(), 6)
+	assert.True(t, IsRule("nested-jsr"))
+	assert.False(t, IsRule("nope"))
+}
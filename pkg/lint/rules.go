@@ -0,0 +1,323 @@
+package lint
+
+import (
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/bytecode"
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+// checkUnusedLabels reports labels no operand or directive refers to, labels
+// exported with .GLOBAL are referred to by it
+func checkUnusedLabels(l *linter) {
+	used := map[string]bool{}
+	usedLocal := map[parser.LocalLabel]bool{}
+	for _, st := range l.prog.Statements {
+		forEachRef(st, func(name string, _ lexer.Position) {
+			if n, _, ok := parser.LocalRef(name); ok {
+				usedLocal[n] = true
+				return
+			}
+			used[name] = true
+		})
+	}
+
+	for i, st := range l.prog.Statements {
+		for _, label := range st.Labels {
+			switch {
+			case label.Name != nil && !used[*label.Name]:
+				l.warn(i, label.Pos, "unused-label", "label '%s' is never used", *label.Name)
+			case label.Local != nil && !usedLocal[*label.Local]:
+				l.warn(i, label.Pos, "unused-label", "local label '%d' is never used", *label.Local)
+			}
+		}
+	}
+}
+
+// checkUnreachable reports the first instruction following an unconditional
+// transfer of control that no label leads to, data in between is skipped
+func checkUnreachable(l *linter) {
+	for _, seg := range l.segments() {
+		after := ""
+		for _, i := range seg {
+			st := l.prog.Statements[i]
+			if len(st.Labels) != 0 {
+				after = ""
+			}
+			if !isCode(st) {
+				continue
+			}
+			if after != "" {
+				l.warn(i, codePos(st), "unreachable", "instruction is never executed, it follows %s and has no label", after)
+			}
+			after = ""
+			if unconditional(st) {
+				after = strings.ToUpper(opName(st))
+			}
+		}
+	}
+}
+
+// checkBareBR reports BR without flags. The encoding with no flags set never
+// branches, but the spec and this assembler take a bare BR as BRnzp, so the
+// rule warns that the branch is unconditional rather than never taken.
+func checkBareBR(l *linter) {
+	for i, st := range l.prog.Statements {
+		if opName(st) == "br" {
+			l.warn(i, codePos(st), "br-no-flags",
+				"BR without condition flags is assembled as BRnzp, write BRnzp or the flags meant")
+		}
+	}
+}
+
+// checkNoHalt reports segments whose last instruction lets execution go on
+// past it
+func checkNoHalt(l *linter) {
+	for _, seg := range l.segments() {
+		last := -1
+		for _, i := range seg {
+			if isCode(l.prog.Statements[i]) {
+				last = i
+			}
+		}
+		if last == -1 || unconditional(l.prog.Statements[last]) {
+			continue
+		}
+		st := l.prog.Statements[last]
+		l.warn(last, codePos(st), "no-halt",
+			"execution runs past the last instruction %s of the segment, HALT expected", strings.ToUpper(opName(st)))
+	}
+}
+
+// checkNestedJSR reports calls within a subroutine, code from a label some
+// JSR jumps to up to RET, made before R7 is saved
+func checkNestedJSR(l *linter) {
+	targets := map[string]bool{}
+	for _, st := range l.prog.Statements {
+		if name := opName(st); isCall(name) && len(st.Op.Args) == 1 && st.Op.Args[0].Expr != nil {
+			if label, ok := labelOperand(st.Op.Args[0].Expr); ok {
+				targets[label] = true
+			}
+		}
+	}
+
+	for _, seg := range l.segments() {
+		sub, saved := "", false
+		for _, i := range seg {
+			st := l.prog.Statements[i]
+			for _, label := range st.Labels {
+				if label.Name != nil && targets[*label.Name] {
+					sub, saved = *label.Name, false
+				}
+			}
+			name := opName(st)
+			switch {
+			case sub == "" || saved:
+			case savesR7(st):
+				saved = true
+			case isCall(name) || name == "jsrr":
+				l.warn(i, codePos(st), "nested-jsr",
+					"%s within subroutine %s overwrites R7 before it is saved, %s can not return",
+					strings.ToUpper(name), sub, sub)
+				saved = true
+			}
+			if name == "ret" {
+				sub = ""
+			}
+		}
+	}
+}
+
+// checkUninitRead reports the first read of every .BLKW without an initial
+// value coming before any store to it in source order, storage whose address
+// is taken with LEA or .FILL may be written through a pointer and is skipped
+func checkUninitRead(l *linter) {
+	storage := map[string]bool{}
+	for _, st := range l.prog.Statements {
+		d := st.Directive
+		if d == nil || !strings.EqualFold(*d.Name, ".blkw") || len(d.Args) != 1 {
+			continue
+		}
+		for _, label := range st.Labels {
+			if label.Name != nil {
+				storage[*label.Name] = true
+			}
+		}
+	}
+
+	done := map[string]bool{}
+	for i, st := range l.prog.Statements {
+		var read bool
+		var arg *parser.Expr
+		switch name := opName(st); {
+		case (name == "ld" || name == "ldi" || name == "sti") && len(st.Op.Args) == 2:
+			read, arg = true, st.Op.Args[1].Expr
+		case (name == "st" || name == "lea") && len(st.Op.Args) == 2:
+			arg = st.Op.Args[1].Expr
+		default:
+			// any other reference such as .FILL takes the address
+			forEachRef(st, func(name string, _ lexer.Position) {
+				done[name] = true
+			})
+			continue
+		}
+		if arg == nil {
+			continue
+		}
+
+		arg.Refs(func(name string, pos lexer.Position) {
+			if !storage[name] || done[name] {
+				return
+			}
+			done[name] = true
+			if read {
+				l.warn(i, pos, "uninit-read", "'%s' is read before anything is stored to it", name)
+			}
+		})
+	}
+}
+
+// segments returns indices of statements of every segment, from .ORIG or
+// .SECTION up to .END
+func (l *linter) segments() [][]int {
+	var segs [][]int
+	var cur []int
+	open := false
+	for i, st := range l.prog.Statements {
+		d := st.Directive
+		switch {
+		case d != nil && (strings.EqualFold(*d.Name, ".orig") || strings.EqualFold(*d.Name, ".section")):
+			if open {
+				segs = append(segs, cur)
+			}
+			cur, open = nil, true
+		case d != nil && strings.EqualFold(*d.Name, ".end"):
+			if open {
+				segs = append(segs, cur)
+			}
+			cur, open = nil, false
+		case open:
+			cur = append(cur, i)
+		}
+	}
+	if open {
+		segs = append(segs, cur)
+	}
+
+	return segs
+}
+
+func isCode(st *parser.Statement) bool {
+	return st.Op != nil || st.Trap != nil
+}
+
+// codePos returns the position of the instruction of a statement, past its
+// labels
+func codePos(st *parser.Statement) lexer.Position {
+	switch {
+	case st.Op != nil:
+		return st.Op.Pos
+	case st.Trap != nil:
+		return st.Trap.Pos
+	}
+
+	return st.Pos
+}
+
+// opName returns the lower-cased name of an instruction or a trap alias
+func opName(st *parser.Statement) string {
+	switch {
+	case st.Op != nil:
+		return strings.ToLower(*st.Op.OpCode)
+	case st.Trap != nil:
+		return strings.ToLower(*st.Trap.Name)
+	}
+
+	return ""
+}
+
+func isCall(name string) bool {
+	return name == "jsr" || name == "call"
+}
+
+// unconditional reports whether execution never goes on to the next
+// statement
+func unconditional(st *parser.Statement) bool {
+	switch name := opName(st); name {
+	case "br", "brnzp", "jmp", "jmpt", "ret", "rti", "halt":
+		return true
+	case "trap":
+		if len(st.Op.Args) == 1 && st.Op.Args[0].Expr != nil {
+			n, ok := numberOperand(st.Op.Args[0].Expr)
+			return ok && n == int(bytecode.TrapHALTAddr)
+		}
+	}
+
+	return false
+}
+
+// savesR7 reports whether an instruction stores or copies R7 elsewhere
+func savesR7(st *parser.Statement) bool {
+	if st.Op == nil {
+		return false
+	}
+	args := st.Op.Args
+	switch opName(st) {
+	case "st", "sti", "str", "push":
+		return len(args) != 0 && isR7(args[0])
+	case "add", "and", "mov":
+		if len(args) < 2 || isR7(args[0]) {
+			return false
+		}
+		for _, arg := range args[1:] {
+			if isR7(arg) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isR7(arg *parser.OpArgs) bool {
+	return arg.Register != nil && *arg.Register == bytecode.R7
+}
+
+// labelOperand returns the label an expression consists of
+func labelOperand(e *parser.Expr) (string, bool) {
+	if len(e.Right) != 0 || len(e.Left.Right) != 0 || e.Left.Left.Operand == nil || e.Left.Left.Operand.Label == nil {
+		return "", false
+	}
+
+	return *e.Left.Left.Operand.Label, true
+}
+
+// numberOperand returns the number an expression consists of
+func numberOperand(e *parser.Expr) (int, bool) {
+	if len(e.Right) != 0 || len(e.Left.Right) != 0 || e.Left.Left.Operand == nil || e.Left.Left.Operand.Number == nil {
+		return 0, false
+	}
+
+	return int(*e.Left.Left.Operand.Number), true
+}
+
+// forEachRef calls f for every label operands and directive arguments of a
+// statement refer to
+func forEachRef(st *parser.Statement, f func(name string, pos lexer.Position)) {
+	switch {
+	case st.Op != nil:
+		for _, arg := range st.Op.Args {
+			if arg.Expr != nil {
+				arg.Expr.Refs(f)
+			}
+		}
+	case st.Directive != nil:
+		for _, arg := range st.Directive.Args {
+			if arg.Expr != nil {
+				arg.Expr.Refs(f)
+			}
+		}
+	}
+}
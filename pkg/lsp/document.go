@@ -0,0 +1,434 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/participle/v2/lexer"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/asm"
+	"github.com/alexey-medvedchikov/lc3/pkg/lint"
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+	"github.com/alexey-medvedchikov/lc3/pkg/preproc"
+)
+
+// document is an open source file along with what the assembler, the
+// parser and the linter found in it
+type document struct {
+	uri   string
+	path  string
+	lines []string
+	// img is nil when the source does not assemble
+	img   *asm.Image
+	diags []Diagnostic
+	occs  []occurrence
+}
+
+// occurrence is a label definition or a reference to it, key identifies
+// the label, numeric local labels are told apart by the statement defining
+// them
+type occurrence struct {
+	key  string
+	name string
+	pos  lexer.Position
+	def  bool
+}
+
+func (c *config) analyze(uri, text string) *document {
+	doc := document{uri: uri, path: uriPath(uri), lines: strings.Split(text, "\n")}
+
+	opts := []asm.Option{
+		asm.WithFilename(doc.path),
+		asm.WithIncludeDirs(append([]string{filepath.Dir(doc.path)}, c.includeDirs...)...),
+	}
+	var parseOpts []parser.Option
+	if c.pseudoOps {
+		opts = append(opts, asm.WithPseudoOps())
+		parseOpts = append(parseOpts, parser.WithPseudoOps())
+	}
+	if c.jmpt {
+		opts = append(opts, asm.WithJMPT())
+		parseOpts = append(parseOpts, parser.WithJMPT())
+	}
+
+	img, err := asm.Assemble(strings.NewReader(text), opts...)
+	if err == nil {
+		doc.img = img
+	}
+	for _, e := range asm.Errors(err) {
+		if e.Pos.Filename == doc.path || e.Pos.Filename == "" {
+			doc.diags = append(doc.diags, doc.diagnostic(e.Pos, SeverityError, "", e.Msg))
+		}
+	}
+
+	// navigation works on the source as written, lines that do not parse
+	// are left out
+	prog, _ := parser.ParseBytes(doc.path, []byte(text), parseOpts...)
+	if prog == nil {
+		return &doc
+	}
+	if err == nil {
+		for _, w := range lint.Check(prog) {
+			doc.diags = append(doc.diags, doc.diagnostic(w.Pos, SeverityWarning, w.Rule, w.Msg))
+		}
+	}
+	doc.collect(prog)
+
+	return &doc
+}
+
+func (doc *document) diagnostic(pos lexer.Position, severity DiagnosticSeverity, code, msg string) Diagnostic {
+	start := position(pos)
+	if pos.Line == 0 {
+		start = Position{}
+	}
+
+	return Diagnostic{
+		Range:    Range{Start: doc.toUTF16(start), End: doc.toUTF16(doc.wordEnd(start))},
+		Severity: severity,
+		Code:     code,
+		Source:   "lc3",
+		Message:  msg,
+	}
+}
+
+// wordEnd returns the end of the word starting at p
+func (doc *document) wordEnd(p Position) Position {
+	if p.Line >= len(doc.lines) {
+		return p
+	}
+	line := []rune(doc.lines[p.Line])
+	end := p.Character
+	for end < len(line) && !strings.ContainsRune(" \t,;", line[end]) {
+		end++
+	}
+	if end == p.Character && end < len(line) {
+		end++
+	}
+
+	return Position{Line: p.Line, Character: end}
+}
+
+// collect finds label definitions and references
+func (doc *document) collect(prog *parser.Program) {
+	locals := map[parser.LocalLabel][]int{}
+	for i, st := range prog.Statements {
+		for _, l := range st.Labels {
+			if l.Local != nil {
+				locals[*l.Local] = append(locals[*l.Local], i)
+			}
+		}
+	}
+
+	for i, st := range prog.Statements {
+		for _, l := range st.Labels {
+			if l.Name != nil {
+				doc.occs = append(doc.occs, occurrence{key: *l.Name, name: *l.Name, pos: l.Pos, def: true})
+			} else {
+				doc.occs = append(doc.occs, occurrence{
+					key: localKey(*l.Local, i), name: fmt.Sprint(*l.Local), pos: l.Pos, def: true,
+				})
+			}
+		}
+
+		ref := func(name string, pos lexer.Position) {
+			key := name
+			if n, forward, ok := parser.LocalRef(name); ok {
+				key = ""
+				if def, ok := resolveLocal(locals[n], i, forward); ok {
+					key = localKey(n, def)
+				}
+			}
+			if key != "" {
+				doc.occs = append(doc.occs, occurrence{key: key, name: name, pos: pos})
+			}
+		}
+		for _, e := range exprs(st) {
+			e.Refs(ref)
+		}
+	}
+}
+
+func exprs(st *parser.Statement) []*parser.Expr {
+	var es []*parser.Expr
+	switch {
+	case st.Op != nil:
+		for _, arg := range st.Op.Args {
+			if arg.Expr != nil {
+				es = append(es, arg.Expr)
+			}
+		}
+	case st.Directive != nil:
+		for _, arg := range st.Directive.Args {
+			if arg.Expr != nil {
+				es = append(es, arg.Expr)
+			}
+		}
+	}
+
+	return es
+}
+
+func localKey(n parser.LocalLabel, stmt int) string {
+	return fmt.Sprintf("%d@%d", n, stmt)
+}
+
+// resolveLocal returns the statement defining the local label a reference
+// from stmt leads to, the way the assembler resolves it
+func resolveLocal(defs []int, stmt int, forward bool) (int, bool) {
+	if forward {
+		for _, d := range defs {
+			if d > stmt {
+				return d, true
+			}
+		}
+		return 0, false
+	}
+	for i := len(defs) - 1; i >= 0; i-- {
+		if defs[i] <= stmt {
+			return defs[i], true
+		}
+	}
+
+	return 0, false
+}
+
+// at returns the label occurrence under the cursor
+func (doc *document) at(p Position) (occurrence, bool) {
+	for _, o := range doc.occs {
+		start := position(o.pos)
+		if start.Line == p.Line && p.Character >= start.Character && p.Character <= start.Character+len(o.name) {
+			return o, true
+		}
+	}
+
+	return occurrence{}, false
+}
+
+func (doc *document) location(o occurrence) Location {
+	start := position(o.pos)
+	end := Position{Line: start.Line, Character: start.Character + len(o.name)}
+
+	return Location{URI: doc.uri, Range: Range{Start: doc.toUTF16(start), End: doc.toUTF16(end)}}
+}
+
+func (doc *document) definition(p Position) []Location {
+	o, ok := doc.at(p)
+	if !ok {
+		return []Location{}
+	}
+	for _, d := range doc.occs {
+		if d.def && d.key == o.key {
+			return []Location{doc.location(d)}
+		}
+	}
+
+	return []Location{}
+}
+
+func (doc *document) references(p Position, withDecl bool) []Location {
+	locs := []Location{}
+	o, ok := doc.at(p)
+	if !ok {
+		return locs
+	}
+	for _, r := range doc.occs {
+		if r.key == o.key && (withDecl || !r.def) {
+			locs = append(locs, doc.location(r))
+		}
+	}
+
+	return locs
+}
+
+// hover describes the address of a label under the cursor or the words the
+// line under it is assembled into
+func (doc *document) hover(p Position) *Hover {
+	if doc.img == nil {
+		return nil
+	}
+
+	if o, ok := doc.at(p); ok {
+		line := -1
+		for _, d := range doc.occs {
+			if d.def && d.key == o.key {
+				line = d.pos.Line
+			}
+		}
+		addr, ok := doc.img.Symbols[o.name]
+		if !ok {
+			e, found := doc.entry(line)
+			if !found {
+				return nil
+			}
+			addr = e.Addr
+		}
+		loc := doc.location(o)
+		return &Hover{
+			Contents: MarkupContent{Kind: "markdown", Value: fmt.Sprintf("`%s` = `x%04X`", o.name, addr)},
+			Range:    &loc.Range,
+		}
+	}
+
+	e, ok := doc.entry(p.Line + 1)
+	if !ok || len(e.Words) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("```\n")
+	for i, w := range e.Words {
+		fmt.Fprintf(&b, "x%04X: x%04X  %016b\n", int(e.Addr)+i, w, w)
+	}
+	b.WriteString("```")
+
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: b.String()}}
+}
+
+// entry returns the source map entry of a statement on a line of the
+// document, the one holding words if there are several
+func (doc *document) entry(line int) (asm.SourceEntry, bool) {
+	var found asm.SourceEntry
+	ok := false
+	for _, e := range doc.img.SourceMap {
+		if e.Pos.Filename != doc.path || e.Pos.Line != line {
+			continue
+		}
+		if !ok || len(e.Words) != 0 {
+			found, ok = e, true
+		}
+	}
+
+	return found, ok
+}
+
+// completion offers directives after a dot and mnemonics, registers and
+// labels otherwise, the word before the cursor is replaced
+func (doc *document) completion(p Position, c *config) []CompletionItem {
+	var line []rune
+	if p.Line < len(doc.lines) {
+		line = []rune(doc.lines[p.Line])
+	}
+	end := p.Character
+	if end > len(line) {
+		end = len(line)
+	}
+	start := end
+	for start > 0 && line[start-1] < utf8.RuneSelf && isWordByte(byte(line[start-1])) {
+		start--
+	}
+	r := Range{
+		Start: doc.toUTF16(Position{Line: p.Line, Character: start}),
+		End:   doc.toUTF16(Position{Line: p.Line, Character: end}),
+	}
+
+	items := []CompletionItem{}
+	add := func(label string, kind CompletionItemKind, detail string) {
+		items = append(items, CompletionItem{
+			Label: label, Kind: kind, Detail: detail, TextEdit: &TextEdit{Range: r, NewText: label},
+		})
+	}
+
+	if strings.HasPrefix(string(line[start:end]), ".") {
+		for _, d := range append(asm.Directives(), preproc.Directives()...) {
+			add(d, KindKeyword, "directive")
+		}
+		return items
+	}
+
+	var parseOpts []parser.Option
+	if c.pseudoOps {
+		parseOpts = append(parseOpts, parser.WithPseudoOps())
+	}
+	if c.jmpt {
+		parseOpts = append(parseOpts, parser.WithJMPT())
+	}
+	for _, m := range parser.Mnemonics(parseOpts...) {
+		add(m, KindKeyword, "instruction")
+	}
+	for i := 0; i < 8; i++ {
+		add(fmt.Sprintf("R%d", i), KindVariable, "register")
+	}
+	seen := map[string]bool{}
+	for _, o := range doc.occs {
+		if !o.def || seen[o.key] || parser.IsLocalLabel(o.name) {
+			continue
+		}
+		seen[o.key] = true
+		detail := "label"
+		if doc.img != nil {
+			if addr, ok := doc.img.Symbols[o.name]; ok {
+				detail = fmt.Sprintf("label x%04X", addr)
+			}
+		}
+		add(o.name, KindReference, detail)
+	}
+
+	return items
+}
+
+func isWordByte(c byte) bool {
+	return c == '.' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// position converts a one-based source position into a zero-based one,
+// characters count runes as lexer columns do
+func position(pos lexer.Position) Position {
+	return Position{Line: pos.Line - 1, Character: pos.Column - 1}
+}
+
+// toUTF16 converts a position counting runes into the UTF-16 code units
+// clients count, characters past the end of the line are kept as they are
+func (doc *document) toUTF16(p Position) Position {
+	if p.Line < 0 || p.Line >= len(doc.lines) {
+		return p
+	}
+	units := 0
+	for i, r := range []rune(doc.lines[p.Line]) {
+		if i == p.Character {
+			return Position{Line: p.Line, Character: units}
+		}
+		units += utf16Len(r)
+	}
+	n := utf8.RuneCountInString(doc.lines[p.Line])
+
+	return Position{Line: p.Line, Character: units + p.Character - n}
+}
+
+// fromUTF16 converts a position of a client into one counting runes, a
+// character in the middle of a surrogate pair points at its rune
+func (doc *document) fromUTF16(p Position) Position {
+	if p.Line < 0 || p.Line >= len(doc.lines) {
+		return p
+	}
+	units := 0
+	runes := []rune(doc.lines[p.Line])
+	for i, r := range runes {
+		units += utf16Len(r)
+		if units > p.Character {
+			return Position{Line: p.Line, Character: i}
+		}
+	}
+
+	return Position{Line: p.Line, Character: len(runes) + p.Character - units}
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+
+	return 1
+}
+
+// uriPath returns the file path of a file:// URI, other URIs are used as is
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+
+	return filepath.FromSlash(u.Path)
+}
@@ -0,0 +1,228 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Option configures the server
+type Option func(c *config)
+
+type config struct {
+	includeDirs []string
+	pseudoOps   bool
+	jmpt        bool
+}
+
+// WithIncludeDirs adds search paths for .INCLUDE files, the directory of a
+// document is searched first
+func WithIncludeDirs(dirs ...string) Option {
+	return func(c *config) {
+		c.includeDirs = append(c.includeDirs, dirs...)
+	}
+}
+
+// WithPseudoOps enables pseudo-instructions, see asm.WithPseudoOps
+func WithPseudoOps() Option {
+	return func(c *config) {
+		c.pseudoOps = true
+	}
+}
+
+// WithJMPT enables JMPT, see asm.WithJMPT
+func WithJMPT() Option {
+	return func(c *config) {
+		c.jmpt = true
+	}
+}
+
+type server struct {
+	config
+	w    io.Writer
+	docs map[string]*document
+}
+
+// Serve runs a language server reading requests from r and writing
+// responses and diagnostics to w until the client asks it to exit or closes
+// r. Documents are synchronised as a whole on every change.
+func Serve(r io.Reader, w io.Writer, opts ...Option) error {
+	s := server{w: w, docs: map[string]*document{}}
+	for _, opt := range opts {
+		opt(&s.config)
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		req, err := readMessage(br)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+
+		result, err := s.handle(req)
+		if req.notification() {
+			if err != nil {
+				err = s.notificationError(req, err)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			rpcErr, ok := err.(*rpcError)
+			if !ok {
+				rpcErr = &rpcError{Code: codeInvalidParams, Message: err.Error()}
+			}
+			err = writeMessage(w, errorResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr})
+		} else {
+			err = writeMessage(w, response{JSONRPC: "2.0", ID: req.ID, Result: result})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeError marks a failure to write to the client, the session cannot go
+// on after it
+type writeError struct {
+	err error
+}
+
+func (e *writeError) Error() string { return e.err.Error() }
+func (e *writeError) Unwrap() error { return e.err }
+
+// notificationError handles an error of a notification, nothing can be
+// answered to them so the error is logged to the client, unknown
+// notifications are ignored
+func (s *server) notificationError(req *request, err error) error {
+	var wErr *writeError
+	if errors.As(err, &wErr) {
+		return wErr.err
+	}
+	var rpcErr *rpcError
+	if errors.As(err, &rpcErr) && rpcErr.Code == codeMethodNotFound {
+		return nil
+	}
+
+	return writeMessage(s.w, notification{
+		JSONRPC: "2.0",
+		Method:  "window/logMessage",
+		Params:  LogMessageParams{Type: MessageError, Message: req.Method + ": " + err.Error()},
+	})
+}
+
+func (s *server) handle(req *request) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1,
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{"."}},
+			},
+			"serverInfo": map[string]string{"name": "lc3"},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.update(p.TextDocument.URI, p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, err
+		}
+		if len(p.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return nil, s.publish(p.TextDocument.URI, nil)
+	case "textDocument/definition":
+		doc, p, err := s.document(req)
+		if err != nil || doc == nil {
+			return []Location{}, err
+		}
+		return doc.definition(doc.fromUTF16(p.Position)), nil
+	case "textDocument/references":
+		var p ReferenceParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, err
+		}
+		doc, ok := s.docs[p.TextDocument.URI]
+		if !ok {
+			return []Location{}, nil
+		}
+		return doc.references(doc.fromUTF16(p.Position), p.Context.IncludeDeclaration), nil
+	case "textDocument/hover":
+		doc, p, err := s.document(req)
+		if err != nil || doc == nil {
+			return nil, err
+		}
+		if h := doc.hover(doc.fromUTF16(p.Position)); h != nil {
+			return h, nil
+		}
+		return nil, nil
+	case "textDocument/completion":
+		doc, p, err := s.document(req)
+		if err != nil || doc == nil {
+			return []CompletionItem{}, err
+		}
+		return doc.completion(doc.fromUTF16(p.Position), &s.config), nil
+	}
+
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "method not found: " + req.Method}
+}
+
+// document returns the open document a position request is about
+func (s *server) document(req *request) (*document, TextDocumentPositionParams, error) {
+	var p TextDocumentPositionParams
+	if err := json.Unmarshal(req.Params, &p); err != nil {
+		return nil, p, err
+	}
+
+	return s.docs[p.TextDocument.URI], p, nil
+}
+
+func (s *server) update(uri, text string) error {
+	doc := s.config.analyze(uri, text)
+	s.docs[uri] = doc
+
+	return s.publish(uri, doc.diags)
+}
+
+func (s *server) publish(uri string, diags []Diagnostic) error {
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+
+	err := writeMessage(s.w, notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  PublishDiagnosticsParams{URI: uri, Diagnostics: diags},
+	})
+	if err != nil {
+		return &writeError{err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testURI = "file:///work/test.asm"
+
+const testSrc = `        .ORIG x3000
+        LEA R0, MSG
+        JSR PRINT
+        HALT
+PRINT   PUTS
+        RET
+MSG     .STRINGZ "hi"
+        .END
+`
+
+type message struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// session feeds method and params pairs to the server and returns what it
+// wrote, requests are numbered from 1
+func session(t *testing.T, calls ...interface{}) []message {
+	t.Helper()
+
+	var in bytes.Buffer
+	id := 0
+	for i := 0; i < len(calls); i += 2 {
+		msg := map[string]interface{}{"jsonrpc": "2.0", "method": calls[i], "params": calls[i+1]}
+		if method := calls[i].(string); method != "initialized" && !strings.HasPrefix(method, "textDocument/did") {
+			id++
+			msg["id"] = id
+		}
+		require.NoError(t, writeMessage(&in, msg))
+	}
+	require.NoError(t, writeMessage(&in, map[string]string{"jsonrpc": "2.0", "method": "exit"}))
+
+	var out bytes.Buffer
+	require.NoError(t, Serve(&in, &out))
+
+	var msgs []message
+	r := bufio.NewReader(&out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		require.NoError(t, err)
+		body := make([]byte, length)
+		_, err = io.ReadFull(r, body)
+		require.NoError(t, err)
+		var msg message
+		require.NoError(t, json.Unmarshal(body, &msg))
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+func open(text string) map[string]interface{} {
+	return map[string]interface{}{"textDocument": map[string]string{"uri": testURI, "text": text}}
+}
+
+func at(line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+		"position":     Position{Line: line, Character: char},
+		"context":      map[string]bool{"includeDeclaration": true},
+	}
+}
+
+func TestServe(t *testing.T) {
+	msgs := session(t,
+		"initialize", map[string]interface{}{},
+		"initialized", map[string]interface{}{},
+		"textDocument/didOpen", open(testSrc),
+		"textDocument/definition", at(2, 13),
+		"textDocument/references", at(4, 1),
+		"textDocument/hover", at(6, 1),
+		"textDocument/hover", at(1, 10),
+		"textDocument/completion", at(2, 13),
+		"shutdown", nil,
+	)
+	require.Len(t, msgs, 8)
+
+	var caps struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}
+	require.NoError(t, json.Unmarshal(msgs[0].Result, &caps))
+	assert.Equal(t, true, caps.Capabilities["definitionProvider"])
+
+	assert.Equal(t, "textDocument/publishDiagnostics", msgs[1].Method)
+	var diags PublishDiagnosticsParams
+	require.NoError(t, json.Unmarshal(msgs[1].Params, &diags))
+	assert.Equal(t, PublishDiagnosticsParams{URI: testURI, Diagnostics: []Diagnostic{}}, diags)
+
+	var defs []Location
+	require.NoError(t, json.Unmarshal(msgs[2].Result, &defs))
+	assert.Equal(t, []Location{{URI: testURI, Range: Range{Start: Position{4, 0}, End: Position{4, 5}}}}, defs)
+
+	var refs []Location
+	require.NoError(t, json.Unmarshal(msgs[3].Result, &refs))
+	assert.Equal(t, []Location{
+		{URI: testURI, Range: Range{Start: Position{2, 12}, End: Position{2, 17}}},
+		{URI: testURI, Range: Range{Start: Position{4, 0}, End: Position{4, 5}}},
+	}, refs)
+
+	var hover Hover
+	require.NoError(t, json.Unmarshal(msgs[4].Result, &hover))
+	assert.Equal(t, "`MSG` = `x3005`", hover.Contents.Value)
+	require.NoError(t, json.Unmarshal(msgs[5].Result, &hover))
+	assert.Equal(t, "```\nx3000: xE004  1110000000000100\n```", hover.Contents.Value)
+
+	var items []CompletionItem
+	require.NoError(t, json.Unmarshal(msgs[6].Result, &items))
+	labels := map[string]string{}
+	for _, item := range items {
+		labels[item.Label] = item.Detail
+		assert.Equal(t, Range{Start: Position{2, 12}, End: Position{2, 13}}, item.TextEdit.Range)
+	}
+	assert.Equal(t, "label x3003", labels["PRINT"])
+	assert.Equal(t, "register", labels["R7"])
+	assert.Equal(t, "instruction", labels["ADD"])
+
+	assert.Equal(t, 7, *msgs[7].ID)
+	assert.Equal(t, "null", string(msgs[7].Result))
+}
+
+func TestServe_Diagnostics(t *testing.T) {
+	msgs := session(t,
+		"textDocument/didOpen", open(".ORIG x3000\nLD R0, NOWHERE\nRET\n.END\n"),
+		"textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]string{"uri": testURI},
+			"contentChanges": []map[string]string{{"text": ".ORIG x3000\nLOOP BR LOOP\n.END\n"}},
+		},
+		"textDocument/didClose", map[string]interface{}{"textDocument": map[string]string{"uri": testURI}},
+		"textDocument/formatting", at(0, 0),
+	)
+	require.Len(t, msgs, 4)
+
+	var diags PublishDiagnosticsParams
+	require.NoError(t, json.Unmarshal(msgs[0].Params, &diags))
+	require.Len(t, diags.Diagnostics, 1)
+	assert.Equal(t, SeverityError, diags.Diagnostics[0].Severity)
+	assert.Equal(t, Range{Start: Position{1, 7}, End: Position{1, 14}}, diags.Diagnostics[0].Range)
+
+	require.NoError(t, json.Unmarshal(msgs[1].Params, &diags))
+	require.Len(t, diags.Diagnostics, 1)
+	assert.Equal(t, SeverityWarning, diags.Diagnostics[0].Severity)
+	assert.Equal(t, "br-no-flags", diags.Diagnostics[0].Code)
+
+	require.NoError(t, json.Unmarshal(msgs[2].Params, &diags))
+	assert.Empty(t, diags.Diagnostics)
+
+	require.NotNil(t, msgs[3].Error)
+	assert.Equal(t, codeMethodNotFound, msgs[3].Error.Code)
+}
+
+func TestServe_UTF16(t *testing.T) {
+	// the emoji takes two UTF-16 code units but a single rune
+	msgs := session(t,
+		"textDocument/didOpen", open("X .FILL 1\n.STRINGZ \"😀\", X\n"),
+		"textDocument/references", at(1, 15),
+		"textDocument/references", at(1, 14),
+	)
+	require.Len(t, msgs, 3)
+
+	var refs []Location
+	require.NoError(t, json.Unmarshal(msgs[1].Result, &refs))
+	assert.Equal(t, []Location{
+		{URI: testURI, Range: Range{Start: Position{0, 0}, End: Position{0, 1}}},
+		{URI: testURI, Range: Range{Start: Position{1, 15}, End: Position{1, 16}}},
+	}, refs)
+
+	require.NoError(t, json.Unmarshal(msgs[2].Result, &refs))
+	assert.Empty(t, refs)
+}
+
+func TestServe_NotificationErrors(t *testing.T) {
+	msgs := session(t,
+		"textDocument/didOpen", map[string]interface{}{"textDocument": "x"},
+		"initialized", map[string]interface{}{},
+		"shutdown", nil,
+	)
+	require.Len(t, msgs, 2)
+
+	assert.Equal(t, "window/logMessage", msgs[0].Method)
+	var log LogMessageParams
+	require.NoError(t, json.Unmarshal(msgs[0].Params, &log))
+	assert.Equal(t, MessageError, log.Type)
+	assert.Contains(t, log.Message, "textDocument/didOpen: ")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestServe_WriteErrors(t *testing.T) {
+	var in bytes.Buffer
+	require.NoError(t, writeMessage(&in, map[string]interface{}{
+		"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": open(testSrc),
+	}))
+
+	assert.EqualError(t, Serve(&in, failingWriter{}), "broken pipe")
+}
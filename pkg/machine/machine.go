@@ -89,6 +89,40 @@ var opTable = [...]func(ex Executor, op uint16){
 	decodeTrap,    // 0b1111 = 15
 }
 
+// Decode calls the method of ex the instruction op stands for, words that
+// are not valid instructions, including ones with reserved bits set, call
+// nothing and make it return false
+func Decode(ex Executor, op uint16) bool {
+	if !valid(op) {
+		return false
+	}
+	opTable[op>>12](ex, op)
+
+	return true
+}
+
+// valid reports whether op is an instruction with its reserved bits clear
+func valid(op uint16) bool {
+	switch op >> 12 {
+	case 0b0001, 0b0101:
+		return op&0b10_0000 != 0 || op&0b0000_0000_0001_1000 == 0
+	case 0b0100:
+		return op&0b1000_0000_0000 != 0 || op&0b0000_0110_0011_1111 == 0
+	case 0b1000:
+		return op&0b0000_1111_1111_1111 == 0
+	case 0b1001:
+		return op&0b0000_0000_0011_1111 == 0b11_1111
+	case 0b1100:
+		return op&0b0000_1110_0011_1111 == 0
+	case 0b1101:
+		return false
+	case 0b1111:
+		return op&0b0000_1111_0000_0000 == 0
+	}
+
+	return true
+}
+
 func decodeInvalid(_ Executor, _ uint16) {
 	panic(interface{}("Invalid operation"))
 }
@@ -296,3 +296,30 @@ func Test_decodeTrap(t *testing.T) {
 
 	decodeTrap(m, bytecode.Trap(1))
 }
+
+func TestDecode(t *testing.T) {
+	m := &mockExecutor{}
+	defer m.AssertExpectations(t)
+	m.On("LDR", R1, R2, int16(-3)).Once()
+	m.On("JSRR", R3).Once()
+
+	assert.True(t, Decode(m, bytecode.LDR(bytecode.R1, bytecode.R2, -3)))
+	assert.True(t, Decode(m, bytecode.JSRR(bytecode.R3)))
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	m := &mockExecutor{}
+	defer m.AssertExpectations(t)
+
+	for _, op := range []uint16{
+		0b0001_000_000_010_000, // ADD with reserved bits
+		0b0100_011_000_000_000, // JSRR with reserved bits
+		0b1000_000_000_000_001, // RTI with reserved bits
+		0b1001_000_000_011_111, // NOT with reserved bits clear
+		0b1100_000_111_000_001, // JMP with reserved bits
+		0b1101_000_000_000_000, // reserved opcode
+		0b1111_0001_0010_0101,  // TRAP with reserved bits
+	} {
+		assert.False(t, Decode(m, op), "%016b", op)
+	}
+}
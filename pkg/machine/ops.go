@@ -56,8 +56,10 @@ func (m *Machine) JSR(offset11 int16) {
 }
 
 func (m *Machine) JSRR(baseReg Register) {
+	// the base is read first as it may be R7 itself
+	target := m.Regs.ReadRU16(baseReg)
 	m.Regs.SetRU16(R7, m.Regs.PC)
-	m.Regs.PC = m.Regs.ReadRU16(baseReg)
+	m.Regs.PC = target
 }
 
 func (m *Machine) LD(dstReg Register, offset9 int16) {
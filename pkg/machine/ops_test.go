@@ -159,6 +159,17 @@ func TestMachine_JSRR(t *testing.T) {
 	assert.Equal(t, newPC, m.Regs.PC)
 }
 
+func TestMachine_JSRR_R7(t *testing.T) {
+	var m Machine
+	m.Regs.SetRU16(R7, 100)
+	m.Regs.PC = 10
+
+	m.JSRR(R7)
+
+	assert.Equal(t, uint16(10), m.Regs.ReadRU16(R7))
+	assert.Equal(t, uint16(100), m.Regs.PC)
+}
+
 func TestMachine_LD_PosOffset(t *testing.T) {
 	var m Machine
 	m.Memory.WriteWord(m.Regs.PC+10, 100)
@@ -10,6 +10,10 @@ type TracedMachine struct {
 
 	w     io.Writer
 	cycle int
+	// pc is the address of the instruction being executed
+	pc uint16
+	// source describes an address in the trace, see SetSource
+	source func(addr uint16) string
 }
 
 var _ Executor = &TracedMachine{}
@@ -22,6 +26,12 @@ func NewTracedMachine(w io.Writer, m *Machine) *TracedMachine {
 	}
 }
 
+// SetSource makes the trace show every instruction along with what source
+// returns for its address, such as a source line or a symbol
+func (t *TracedMachine) SetSource(source func(addr uint16) string) {
+	t.source = source
+}
+
 func (t *TracedMachine) Start(trace func(*Machine)) {
 	t.Machine.EnableClock()
 
@@ -35,6 +45,7 @@ func (t *TracedMachine) Start(trace func(*Machine)) {
 }
 
 func (t *TracedMachine) Step() {
+	t.pc = t.Machine.Regs.PC
 	op := t.Machine.Memory.ReadWord(t.Machine.Regs.PC)
 	t.Machine.Regs.PC++
 	opTableKey := op >> 12
@@ -44,6 +55,10 @@ func (t *TracedMachine) Step() {
 
 func (t *TracedMachine) log(format string, args ...interface{}) {
 	args = append([]interface{}{t.cycle}, args...)
+	if t.source != nil {
+		format = "%s: " + format
+		args = append([]interface{}{args[0], t.source(t.pc)}, args[1:]...)
+	}
 	s := fmt.Sprintf("%d: "+format, args...)
 	_, _ = t.w.Write([]byte(s))
 }
@@ -2,6 +2,7 @@ package machine
 
 import (
 	"bytes"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,3 +34,21 @@ func TestTracedMachine_Start(t *testing.T) {
 
 	assert.Equal(t, "0: AND R0 R0 #0\n1: STI R0 #0\n", buf.String())
 }
+
+func TestTracedMachine_SetSource(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewTracedMachine(&buf, &Machine{})
+	m.SetSource(func(addr uint16) string {
+		return fmt.Sprintf("x%04X", addr)
+	})
+
+	m.Memory.WriteSegment(UserStart, []uint16{
+		bytecode.AndImm(bytecode.R0, bytecode.R0, 0),
+		bytecode.STI(bytecode.R0, 0),
+		ControlReg,
+	})
+	m.Init()
+	m.Start(func(m *Machine) {})
+
+	assert.Equal(t, "0: x3000: AND R0 R0 #0\n1: x3001: STI R0 #0\n", buf.String())
+}
@@ -0,0 +1,122 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// ErrorList holds every error found in a source, in source order
+type ErrorList []participle.Error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Err returns the list as an error or nil when it is empty
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+
+	return l
+}
+
+// Suggest returns the candidate closest to a misspelled name, ignoring case,
+// or "" when none of them is close enough
+func Suggest(name string, candidates []string) string {
+	best, bestDist := "", len(name)/3+1
+	for _, c := range candidates {
+		if c == name {
+			continue
+		}
+		d := distance(strings.ToLower(name), strings.ToLower(c))
+		if d < bestDist || d == bestDist && best != "" && c < best {
+			best, bestDist = c, d
+		}
+	}
+
+	return best
+}
+
+// distance counts insertions, deletions, substitutions and transpositions of
+// adjacent characters turning a into b
+func distance(a, b string) int {
+	// rows[i%3] holds distances from a[:i] to every prefix of b
+	rows := [3][]int{make([]int, len(b)+1), make([]int, len(b)+1), make([]int, len(b)+1)}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur, prev, prev2 := rows[i%3], rows[(i+2)%3], rows[(i+1)%3]
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+	}
+
+	return rows[len(a)%3][len(b)]
+}
+
+func min(v int, vs ...int) int {
+	for _, x := range vs {
+		if x < v {
+			v = x
+		}
+	}
+
+	return v
+}
+
+// Mnemonics returns names of the instructions, trap aliases and every form
+// of BR in upper case
+func Mnemonics(opts ...Option) []string {
+	return newConfig(opts).mnemonics()
+}
+
+// mnemonics lists instruction names to suggest for misspelled ones
+func (c config) mnemonics() []string {
+	var names []string
+	for _, n := range append(strings.Split(c.opCodes, "|"), strings.Split(trapAliases, "|")...) {
+		if strings.HasPrefix(n, "br") {
+			continue
+		}
+		names = append(names, strings.ToUpper(n))
+	}
+	for _, n := range branches {
+		names = append(names, strings.ToUpper(n))
+	}
+
+	return names
+}
+
+// hint adds a suggestion to a syntax error of a line starting with what
+// looks like a misspelled instruction or JMPT without WithJMPT, these are
+// read as labels
+func (c config) hint(err participle.Error, tokens []lexer.Token) participle.Error {
+	label := c.lexer.Symbols()["Label"]
+	for i := 0; i < len(tokens) && i < 2 && tokens[i].Type == label; i++ {
+		if !c.jmpt && strings.EqualFold(tokens[i].Value, jmptOpCode) {
+			return participle.Errorf(err.Position(), "%s, JMPT requires --jmpt", err.Message())
+		}
+		if s := Suggest(tokens[i].Value, c.mnemonics()); s != "" {
+			return participle.Errorf(err.Position(), "%s, did you mean '%s'?", err.Message(), s)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,212 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// Line is a single source line along with the position it originates from
+type Line struct {
+	Pos  lexer.Position
+	Text string
+	// Expanded lines are produced by a macro invocation at Pos rather than
+	// written in the source, all their tokens are positioned at Pos
+	Expanded bool
+}
+
+// SplitLines splits src into lines positioned within the file filename
+func SplitLines(filename string, src []byte) []Line {
+	text := strings.TrimSuffix(string(src), "\n")
+	if text == "" {
+		return nil
+	}
+
+	var lines []Line
+	offset := 0
+	for i, s := range strings.Split(text, "\n") {
+		lines = append(lines, Line{
+			Pos:  lexer.Position{Filename: filename, Offset: offset, Line: i + 1, Column: 1},
+			Text: strings.TrimSuffix(s, "\r"),
+		})
+		offset += len(s) + 1
+	}
+
+	return lines
+}
+
+// ParseLines parses a program from lines which may come from different
+// places, tokens carry positions of the lines they were read from. Lines are
+// parsed one by one so that a syntax error does not hide the following
+// ones, all of them are returned as an ErrorList along with the statements
+// of the lines parsed successfully. A label starting a broken line is still
+// defined to spare undefined label errors where it is referred to.
+func ParseLines(lines []Line, opts ...Option) (*Program, error) {
+	c := newConfig(opts)
+	eol := c.lexer.Symbols()["EOL"]
+
+	p := Program{}
+	if len(lines) != 0 {
+		p.Pos = lines[0].Pos
+	}
+
+	var errs ErrorList
+	for _, l := range lines {
+		tokens, err := lex(c.lexer, l)
+		if err != nil {
+			errs = append(errs, toError(err, l.Pos))
+			continue
+		}
+		if litErrs := checkLiterals(c.lexer.Symbols(), tokens); len(litErrs) != 0 {
+			errs = append(errs, litErrs...)
+			p.Statements = append(p.Statements, c.brokenLabel(tokens)...)
+			continue
+		}
+		end := l.position(lexer.Position{Offset: len(l.Text), Column: len(l.Text) + 1})
+		tokens = append(tokens, lexer.Token{Type: eol, Value: "\n", Pos: end})
+
+		peek, err := lexer.Upgrade(&tokenLexer{tokens: tokens, eof: lexer.EOFToken(end)})
+		if err != nil {
+			return nil, err
+		}
+
+		var lp Program
+		if err := c.parser.ParseFromLexer(peek, &lp); err != nil {
+			errs = append(errs, c.hint(toError(err, l.Pos), tokens))
+			p.Statements = append(p.Statements, c.brokenLabel(tokens)...)
+			continue
+		}
+		p.Statements = append(p.Statements, lp.Statements...)
+	}
+
+	return &p, errs.Err()
+}
+
+// brokenLabel returns the statement defining the label a line that failed
+// to parse starts with
+func (c config) brokenLabel(tokens []lexer.Token) []*Statement {
+	if len(tokens) == 0 || tokens[0].Type != c.lexer.Symbols()["Label"] {
+		return nil
+	}
+	name := tokens[0].Value
+
+	return []*Statement{{
+		Pos:    tokens[0].Pos,
+		Labels: []*Label{{Pos: tokens[0].Pos, Name: &name}},
+	}}
+}
+
+// checkLiterals converts numbers, characters and strings of a line ahead of
+// the parser, which drops positions of the errors they fail with
+func checkLiterals(symbols map[string]lexer.TokenType, tokens []lexer.Token) ErrorList {
+	var errs ErrorList
+	for _, tok := range tokens {
+		var err error
+		switch tok.Type {
+		case symbols["Number"], symbols["Char"]:
+			var n Number
+			err = n.Capture([]string{tok.Value})
+		case symbols["String"]:
+			var s String
+			err = s.Capture([]string{tok.Value})
+		}
+		if err != nil {
+			errs = append(errs, participle.Errorf(tok.Pos, "%s", err))
+		}
+	}
+
+	return errs
+}
+
+// toError turns err into a positioned error, errors without a position are
+// put at the start of the line
+func toError(err error, pos lexer.Position) participle.Error {
+	perr, ok := err.(participle.Error)
+	if !ok {
+		return participle.Errorf(pos, "%s", err)
+	}
+	if perr.Position().Line == 0 {
+		return participle.Errorf(pos, "%s", perr.Message())
+	}
+
+	return perr
+}
+
+// Lex splits a line into tokens positioned within the source
+func Lex(l Line, opts ...Option) ([]lexer.Token, error) {
+	return lex(newConfig(opts).lexer, l)
+}
+
+// Symbols maps names of the lexer rules such as "Label" or "OpCode" to the
+// types of tokens Lex returns
+func Symbols() map[string]lexer.TokenType {
+	return asmLexer.Symbols()
+}
+
+func lex(def *lexer.StatefulDefinition, l Line) ([]lexer.Token, error) {
+	lx, err := def.LexString(l.Pos.Filename, l.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []lexer.Token
+	for {
+		tok, err := lx.Next()
+		if err != nil {
+			if perr, ok := err.(participle.Error); ok {
+				return nil, participle.Errorf(l.position(perr.Position()), "%s", perr.Message())
+			}
+			return nil, err
+		}
+		if tok.EOF() {
+			return tokens, nil
+		}
+		tok.Pos = l.position(tok.Pos)
+		tokens = append(tokens, tok)
+	}
+}
+
+// IsLabel reports whether s would be read as a label rather than as an
+// instruction, register, number or directive
+func IsLabel(s string) bool {
+	lex, err := asmLexer.LexString("", s)
+	if err != nil {
+		return false
+	}
+	tokens, err := lexer.ConsumeAll(lex)
+	if err != nil || len(tokens) != 2 {
+		return false
+	}
+
+	return tokens[0].Type == asmLexer.Symbols()["Label"] && tokens[0].Value == s
+}
+
+// position translates a position within the line text into the source one
+func (l Line) position(pos lexer.Position) lexer.Position {
+	if l.Expanded {
+		return l.Pos
+	}
+
+	return lexer.Position{
+		Filename: l.Pos.Filename,
+		Offset:   l.Pos.Offset + pos.Offset,
+		Line:     l.Pos.Line,
+		Column:   l.Pos.Column + pos.Column - 1,
+	}
+}
+
+type tokenLexer struct {
+	tokens []lexer.Token
+	eof    lexer.Token
+}
+
+func (l *tokenLexer) Next() (lexer.Token, error) {
+	if len(l.tokens) == 0 {
+		return l.eof, nil
+	}
+	tok := l.tokens[0]
+	l.tokens = l.tokens[1:]
+
+	return tok, nil
+}
@@ -1,9 +1,12 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
@@ -13,7 +16,7 @@ import (
 
 type Program struct {
 	Pos        lexer.Position
-	Statements []*Statement `parser:"( @@ EOL* )*"`
+	Statements []*Statement `parser:"EOL* ( @@ EOL* )*"`
 }
 
 type Statement struct {
@@ -25,9 +28,65 @@ type Statement struct {
 	Comment   *Comment   `parser:"@@?" json:",omitempty"`
 }
 
+// Label is either a named label or a numeric local one such as "1:", local
+// labels may be defined many times and are referred to as 1b for the nearest
+// definition backward and 1f for the nearest one forward
 type Label struct {
-	Pos  lexer.Position
-	Name *string `parser:"@Label" json:",omitempty"`
+	Pos   lexer.Position
+	Name  *string     `parser:"  @Label" json:",omitempty"`
+	Local *LocalLabel `parser:"| @Number ':'" json:",omitempty"`
+}
+
+type LocalLabel int
+
+func (l *LocalLabel) Capture(values []string) error {
+	if len(values) != 1 {
+		return fmt.Errorf("local label can only capture single value: '%+v'", values)
+	}
+
+	v := values[0]
+	if !IsLocalLabel(v) {
+		return fmt.Errorf("local label must be a decimal number: '%s'", v)
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*l = LocalLabel(n)
+
+	return nil
+}
+
+// IsLocalLabel reports whether s names a numeric local label
+func IsLocalLabel(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// LocalRef splits a reference to a local label such as 1b or 1f into the
+// label number and whether it refers forward
+func LocalRef(s string) (LocalLabel, bool, bool) {
+	if len(s) < 2 || !IsLocalLabel(s[:len(s)-1]) {
+		return 0, false, false
+	}
+	dir := s[len(s)-1]
+	if dir != 'b' && dir != 'f' {
+		return 0, false, false
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil {
+		return 0, false, false
+	}
+
+	return LocalLabel(n), dir == 'f', true
 }
 
 type Comment struct {
@@ -38,14 +97,13 @@ type Comment struct {
 type Directive struct {
 	Pos  lexer.Position
 	Name *string         `parser:"@Directive" json:",omitempty"`
-	Args []*DirectiveArg `parser:"@@*" json:",omitempty"`
+	Args []*DirectiveArg `parser:"( @@ ( ','? @@ )* )?" json:",omitempty"`
 }
 
 type DirectiveArg struct {
 	Pos    lexer.Position
-	Label  *string `parser:"@Label" json:",omitempty"`
-	Number *Number `parser:"| @Number" json:",omitempty"`
-	String *String `parser:"| @String" json:",omitempty"`
+	String *String `parser:"@String" json:",omitempty"`
+	Expr   *Expr   `parser:"| @@" json:",omitempty"`
 }
 
 type Op struct {
@@ -57,14 +115,82 @@ type Op struct {
 type OpArgs struct {
 	Pos      lexer.Position
 	Register *bytecode.Register `parser:"@Register" json:",omitempty"`
-	Label    *string            `parser:"| @Label" json:",omitempty"`
-	Number   *Number            `parser:"| @Number" json:",omitempty"`
+	Expr     *Expr              `parser:"| @@" json:",omitempty"`
+}
+
+// Expr is an arithmetic expression over numbers and labels, such as
+// TABLE+3 or (END-START)*2. Multiplicative operators bind tighter than
+// additive ones.
+type Expr struct {
+	Pos   lexer.Position
+	Left  *Term     `parser:"@@"`
+	Right []*ExprOp `parser:"@@*" json:",omitempty"`
+}
+
+// Refs calls f for every label the expression refers to
+func (e *Expr) Refs(f func(name string, pos lexer.Position)) {
+	e.Left.refs(f)
+	for _, op := range e.Right {
+		op.Term.refs(f)
+	}
+}
+
+type ExprOp struct {
+	Op   string `parser:"@('+' | '-')"`
+	Term *Term  `parser:"@@"`
+}
+
+type Term struct {
+	Pos   lexer.Position
+	Left  *Unary    `parser:"@@"`
+	Right []*TermOp `parser:"@@*" json:",omitempty"`
+}
+
+func (t *Term) refs(f func(name string, pos lexer.Position)) {
+	t.Left.refs(f)
+	for _, op := range t.Right {
+		op.Unary.refs(f)
+	}
+}
+
+type TermOp struct {
+	Op    string `parser:"@('*' | '/' | '%')"`
+	Unary *Unary `parser:"@@"`
+}
+
+type Unary struct {
+	Pos     lexer.Position
+	Op      *string  `parser:"( @('-' | '~')" json:",omitempty"`
+	Unary   *Unary   `parser:"  @@" json:",omitempty"`
+	Operand *Operand `parser:"| @@ )" json:",omitempty"`
+}
+
+func (u *Unary) refs(f func(name string, pos lexer.Position)) {
+	switch {
+	case u.Unary != nil:
+		u.Unary.refs(f)
+	case u.Operand == nil:
+	case u.Operand.Label != nil:
+		f(*u.Operand.Label, u.Operand.Pos)
+	case u.Operand.Sub != nil:
+		u.Operand.Sub.Refs(f)
+	}
+}
+
+type Operand struct {
+	Pos    lexer.Position
+	Number *Number `parser:"@(Number | Char)" json:",omitempty"`
+	Label  *string `parser:"| @Label" json:",omitempty"`
+	Sub    *Expr   `parser:"| '(' @@ ')'" json:",omitempty"`
 }
 
 type Trap struct {
+	Pos  lexer.Position
 	Name *string `parser:"@Trap" json:",omitempty"`
 }
 
+// Number is a numeric literal: decimal as 10 or #-10, hexadecimal as x1F or
+// 0x1F, binary as b101 or 0b101, or a character as 'A' or '\n'
 type Number int
 
 func (n *Number) Capture(values []string) error {
@@ -73,24 +199,60 @@ func (n *Number) Capture(values []string) error {
 	}
 
 	v := values[0]
-	base := -1
-	switch v[0] {
-	case 'x':
-		base = 16
-	case '#':
-		base = 10
-	}
-
-	if base != -1 {
-		i, err := strconv.ParseInt(v[1:], base, 64)
+	if v[0] == '\'' {
+		c, err := unquoteChar(v)
 		if err != nil {
 			return err
 		}
-		*n = Number(i)
+		*n = Number(c)
 		return nil
 	}
 
-	return fmt.Errorf("unknown number format: '%+v'", v)
+	digits, base := v, 10
+	switch lower := strings.ToLower(v); {
+	case strings.HasPrefix(lower, "0x"):
+		digits, base = v[2:], 16
+	case strings.HasPrefix(lower, "0b"):
+		digits, base = v[2:], 2
+	case lower[0] == 'x':
+		digits, base = v[1:], 16
+	case lower[0] == 'b':
+		digits, base = v[1:], 2
+	case lower[0] == '#':
+		digits = v[1:]
+	}
+
+	i, err := strconv.ParseInt(digits, base, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return fmt.Errorf("number %s is out of range", v)
+		}
+		return fmt.Errorf("invalid %s number '%s'", baseNames[base], v)
+	}
+	*n = Number(i)
+
+	return nil
+}
+
+var baseNames = map[int]string{2: "binary", 10: "decimal", 16: "hexadecimal"}
+
+func unquoteChar(v string) (byte, error) {
+	s := v[1 : len(v)-1]
+	switch {
+	case len(s) == 1 && s[0] != '\\':
+		return s[0], nil
+	case len(s) == 2 && s[0] == '\\':
+		if s[1] == '\'' {
+			return '\'', nil
+		}
+		c, ok := stringEscapes[s[1]]
+		if !ok {
+			return 0, fmt.Errorf("unknown escape sequence '\\%c' in character %s", s[1], v)
+		}
+		return c, nil
+	}
+
+	return 0, fmt.Errorf("character literal %s must hold a single character", v)
 }
 
 type String string
@@ -100,43 +262,163 @@ func (s *String) Capture(values []string) error {
 		return fmt.Errorf("string can only capture single value: '%+v'", values)
 	}
 	v := values[0]
-	*s = String(v[1 : len(v)-1])
+	v = v[1 : len(v)-1]
+
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			b.WriteByte(v[i])
+			continue
+		}
+		i++
+		c, ok := stringEscapes[v[i]]
+		if !ok {
+			return fmt.Errorf("unknown escape sequence '\\%c' in string", v[i])
+		}
+		b.WriteByte(c)
+	}
+	*s = String(b.String())
 
 	return nil
 }
 
+var stringEscapes = map[byte]byte{
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+	'"':  '"',
+	'\\': '\\',
+	'0':  0,
+}
+
+const (
+	// opCodes are the instructions of the LC-3 spec along with RET,
+	// condition codes of BR go in the nzp order
+	opCodes = `add|and|brn?z?p?|jmp|jsr|jsrr|ld|ldi|ldr|lea|not|ret|rti|st|sti|str|trap`
+	// jmptOpCode is JMPT of lc3tools, only recognized with WithJMPT
+	jmptOpCode = `jmpt`
+	// pseudoOpCodes are extended instructions the assembler expands into
+	// base ones, they are only recognized with WithPseudoOps
+	pseudoOpCodes = `mov|clr|sub|neg|inc|dec|push|pop|call|ldimm`
+	// trapAliases are TRAP instructions with names of their own
+	trapAliases = `getc|in|out|puts|putsp|halt`
+)
+
+// branches lists every form of BR the opCodes pattern accepts
+var branches = []string{"br", "brn", "brz", "brp", "brnz", "brnp", "brzp", "brnzp"}
+
 var (
-	asmLexer = lexer.MustSimple([]lexer.Rule{
+	asmLexer  = newLexer(opCodes)
+	asmParser = newParser(asmLexer)
+
+	// grammars holds lexers and parsers built for sets of opcodes other
+	// than the default one
+	grammars   = map[string]grammar{opCodes: {lexer: asmLexer, parser: asmParser}}
+	grammarsMu sync.Mutex
+)
+
+type grammar struct {
+	lexer  *lexer.StatefulDefinition
+	parser *participle.Parser
+}
+
+func grammarFor(opCodes string) grammar {
+	grammarsMu.Lock()
+	defer grammarsMu.Unlock()
+
+	g, ok := grammars[opCodes]
+	if !ok {
+		g.lexer = newLexer(opCodes)
+		g.parser = newParser(g.lexer)
+		grammars[opCodes] = g
+	}
+
+	return g
+}
+
+func newLexer(opCodes string) *lexer.StatefulDefinition {
+	return lexer.MustSimple([]lexer.Rule{
 		{Name: "EOL", Pattern: `[\r\n]+`},
-		{Name: "Number", Pattern: `x-?[[:xdigit:]]+|#-?\d+`},
-		{Name: "String", Pattern: `"[^"]*"`},
-		{
-			Name: "OpCode",
-			Pattern: `(?i)\b(add|and|br|brnzp|brnz|brnp|brzp|brn|brz|bzp|jmp|jmpt|jsr` +
-				`|jsrr|ld|ldi|ldr|lea|not|rti|st|sti|str|trap)\b`,
-		},
-		{Name: "Trap", Pattern: "(?i)\b(getc|in|out|puts|putsp|halt)\b"},
+		{Name: "Number", Pattern: `(?i)0x[[:xdigit:]]+\b|0b[01]+\b|x-?[[:xdigit:]]+\b|b[01]+\b|#-?\d+\b|\d+\b`},
+		{Name: "Char", Pattern: `'(\\.|[^'\\])*'`},
+		{Name: "String", Pattern: `"(\\.|[^"\\])*"`},
+		{Name: "OpCode", Pattern: `(?i)\b(` + opCodes + `)\b`},
+		{Name: "Trap", Pattern: `(?i)\b(` + trapAliases + `)\b`},
 		{Name: "Directive", Pattern: `\.[[:alpha:]]\w*`},
-		{Name: "Register", Pattern: `(?i)r\d`},
+		{Name: "Register", Pattern: `(?i)\br[0-7]\b`},
 		{Name: "Label", Pattern: `[a-zA-Z0-9_]\w*`},
 		{Name: "Comment", Pattern: `;.*`},
 		{Name: "Comma", Pattern: `,`},
 		{Name: "Colon", Pattern: `:`},
+		{Name: "Operator", Pattern: `[-+*/%~()]`},
 		{Name: "skip-whitespace", Pattern: `[[:blank:]]+`},
 	})
+}
 
-	asmParser = participle.MustBuild(&Program{},
-		participle.Lexer(asmLexer),
+func newParser(def lexer.Definition) *participle.Parser {
+	return participle.MustBuild(&Program{},
+		participle.Lexer(def),
 		participle.UseLookahead(1),
 	)
-)
+}
 
-func Parse(r io.Reader) (*Program, error) {
-	var p Program
+type Option func(c *config)
+
+type config struct {
+	pseudoOps bool
+	jmpt      bool
+
+	opCodes string
+	lexer   *lexer.StatefulDefinition
+	parser  *participle.Parser
+}
+
+// WithPseudoOps makes MOV, CLR, SUB, NEG, INC, DEC, PUSH, POP, CALL and LDIMM
+// instructions rather than labels
+func WithPseudoOps() Option {
+	return func(c *config) {
+		c.pseudoOps = true
+	}
+}
 
-	if err := asmParser.Parse("", r, &p); err != nil {
+// WithJMPT makes JMPT, the jump clearing the privilege bit lc3tools
+// provides for operating system code, an instruction rather than a label
+func WithJMPT() Option {
+	return func(c *config) {
+		c.jmpt = true
+	}
+}
+
+func newConfig(opts []Option) config {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	c.opCodes = opCodes
+	if c.jmpt {
+		c.opCodes += "|" + jmptOpCode
+	}
+	if c.pseudoOps {
+		c.opCodes += "|" + pseudoOpCodes
+	}
+	g := grammarFor(c.opCodes)
+	c.lexer, c.parser = g.lexer, g.parser
+
+	return c
+}
+
+// Parse reads a program, see ParseLines for errors it returns
+func Parse(r io.Reader, opts ...Option) (*Program, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
 		return nil, err
 	}
 
-	return &p, nil
+	return ParseBytes("", src, opts...)
+}
+
+// ParseBytes parses a program from src, see ParseLines for errors it returns
+func ParseBytes(filename string, src []byte, opts ...Option) (*Program, error) {
+	return ParseLines(SplitLines(filename, src), opts...)
 }
@@ -2,9 +2,12 @@ package parser
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
+	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const code = `        .ORIG x0000
@@ -172,6 +175,249 @@ func TestParse(t *testing.T) {
 
 	in := bytes.NewBuffer([]byte(code))
 
-	_, err := Parse(in)
+	_, err := Parse(in, WithJMPT())
 	assert.NoError(t, err)
 }
+
+func TestParse_JMPT(t *testing.T) {
+	_, err := Parse(strings.NewReader("JMPT R7\n"))
+	assert.EqualError(t, err, `1:6: unexpected token "R7", JMPT requires --jmpt`)
+
+	prog, err := Parse(strings.NewReader("JMPT R7\n"), WithJMPT())
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 1)
+	assert.Equal(t, "JMPT", *prog.Statements[0].Op.OpCode)
+}
+
+func TestLexer_Mnemonics(t *testing.T) {
+	tests := map[string][]string{
+		"OpCode": {
+			"ADD", "AND", "BR", "BRn", "BRz", "BRp", "BRnz", "BRnp", "BRzp", "BRnzp",
+			"JMP", "JSR", "JSRR", "LD", "LDI", "LDR", "LEA", "NOT", "RET", "RTI",
+			"ST", "STI", "STR", "TRAP",
+		},
+		"Trap":      {"GETC", "OUT", "PUTS", "IN", "PUTSP", "HALT"},
+		"Directive": {".ORIG", ".FILL", ".BLKW", ".STRINGZ", ".END"},
+		"Register":  {"R0", "R1", "R2", "R3", "R4", "R5", "R6", "R7"},
+		// not in the spec, these are labels
+		"Label": {"BZP", "BRpn", "BRzn", "BRnn", "R8", "R9", "R10", "HALTS", "ADDR", "JMPT"},
+	}
+
+	symbols := asmLexer.Symbols()
+	for typ, words := range tests {
+		for _, w := range words {
+			for _, v := range []string{w, strings.ToLower(w), strings.ToUpper(w[:2]) + strings.ToLower(w[2:])} {
+				tokens, err := Lex(Line{Text: v})
+				require.NoError(t, err, v)
+				if assert.Len(t, tokens, 1, v) {
+					assert.Equal(t, symbols[typ], tokens[0].Type, v)
+					assert.Equal(t, v, tokens[0].Value)
+				}
+			}
+		}
+	}
+}
+
+func TestNumber_Capture(t *testing.T) {
+	tests := map[string]Number{
+		"10":     10,
+		"#10":    10,
+		"#-10":   -10,
+		"x1F":    0x1F,
+		"X1f":    0x1F,
+		"x-1":    -1,
+		"0x1F":   0x1F,
+		"0XFFFF": 0xFFFF,
+		"b101":   5,
+		"B101":   5,
+		"0b1111": 15,
+		"'A'":    'A',
+		`'\n'`:   '\n',
+		`'\''`:   '\'',
+		`'"'`:    '"',
+	}
+
+	for v, want := range tests {
+		var n Number
+		if assert.NoError(t, n.Capture([]string{v}), v) {
+			assert.Equal(t, want, n, v)
+		}
+	}
+
+	for _, v := range []string{"'AB'", "''", `'\q'`, "x1FFFFFFFFFFFFFFFFF", "0b2"} {
+		var n Number
+		assert.Error(t, n.Capture([]string{v}), v)
+	}
+}
+
+func TestParse_Literals(t *testing.T) {
+	p, err := Parse(strings.NewReader(".FILL 0x10, 0b11, b101, 'A', ';' ; comment\n"))
+	require.NoError(t, err)
+
+	var got []Number
+	for _, arg := range p.Statements[0].Directive.Args {
+		got = append(got, *arg.Expr.Left.Left.Operand.Number)
+	}
+	assert.Equal(t, []Number{0x10, 3, 5, 'A', ';'}, got)
+	assert.NotNil(t, p.Statements[0].Comment)
+
+	// binary literals take over labels looking like them, as in lc3tools
+	_, err = Parse(strings.NewReader("b1 RET\n"))
+	assert.Error(t, err)
+	_, err = Parse(strings.NewReader("b1: RET\n"))
+	assert.Error(t, err)
+	assert.True(t, IsLabel("b12"))
+	assert.True(t, IsLabel("xyz"))
+	assert.True(t, IsLabel("x1_LOOP"))
+}
+
+func TestString_Capture(t *testing.T) {
+	var s String
+
+	assert.NoError(t, s.Capture([]string{`"\nInput \"a\"\tb\\\0"`}))
+	assert.Equal(t, String("\nInput \"a\"\tb\\\x00"), s)
+
+	assert.Error(t, s.Capture([]string{`"\q"`}))
+}
+
+func TestParse_DirectiveArgs(t *testing.T) {
+	p, err := Parse(strings.NewReader(`.BLKW 2, x10` + "\n" + `.BLKW 2 x10`))
+	require.NoError(t, err)
+
+	for _, st := range p.Statements {
+		require.Len(t, st.Directive.Args, 2)
+		assert.Equal(t, Number(2), *st.Directive.Args[0].Expr.Left.Left.Operand.Number)
+		assert.Equal(t, Number(0x10), *st.Directive.Args[1].Expr.Left.Left.Operand.Number)
+	}
+}
+
+func TestParse_Expr(t *testing.T) {
+	p, err := Parse(strings.NewReader(`LD R0, TABLE+3*(SIZE-1)`))
+	require.NoError(t, err)
+
+	e := p.Statements[0].Op.Args[1].Expr
+	assert.Equal(t, "TABLE", *e.Left.Left.Operand.Label)
+	require.Len(t, e.Right, 1)
+	assert.Equal(t, "+", e.Right[0].Op)
+
+	term := e.Right[0].Term
+	assert.Equal(t, Number(3), *term.Left.Operand.Number)
+	require.Len(t, term.Right, 1)
+	assert.Equal(t, "*", term.Right[0].Op)
+
+	sub := term.Right[0].Unary.Operand.Sub
+	assert.Equal(t, "SIZE", *sub.Left.Left.Operand.Label)
+	assert.Equal(t, "-", sub.Right[0].Op)
+
+	p, err = Parse(strings.NewReader(`ADD R0, R0, -1`))
+	require.NoError(t, err)
+	u := p.Statements[0].Op.Args[2].Expr.Left.Left
+	assert.Equal(t, "-", *u.Op)
+	assert.Equal(t, Number(1), *u.Unary.Operand.Number)
+}
+
+func TestParseLines(t *testing.T) {
+	lines := SplitLines("a.asm", []byte(".ORIG x3000\n  ADD R1, R1, #1\n"))
+	lines = append(lines, Line{Pos: lines[1].Pos, Text: "HALT", Expanded: true})
+
+	p, err := ParseLines(lines)
+	require.NoError(t, err)
+	require.Len(t, p.Statements, 3)
+
+	assert.Equal(t, lexer.Position{Filename: "a.asm", Offset: 14, Line: 2, Column: 3}, p.Statements[1].Pos)
+	assert.Equal(t, lines[1].Pos, p.Statements[2].Pos)
+}
+
+func TestParseLines_Errors(t *testing.T) {
+	src := ".ORIG x3000\nLOOP ADDD R1, R1, #1\n  HALT\n  ADD R1 R1\n.END\n"
+
+	p, err := ParseBytes("a.asm", []byte(src))
+	require.Error(t, err)
+
+	errs := err.(ErrorList)
+	require.Len(t, errs, 2)
+	assert.Equal(t, 2, errs[0].Position().Line)
+	assert.Contains(t, errs[0].Message(), "did you mean 'ADD'?")
+	assert.Equal(t, 4, errs[1].Position().Line)
+
+	// statements of the good lines are kept, the label of the broken one too
+	require.Len(t, p.Statements, 4)
+	assert.Equal(t, "LOOP", *p.Statements[1].Labels[0].Name)
+	assert.Nil(t, p.Statements[1].Op)
+}
+
+func TestParseLines_LiteralErrors(t *testing.T) {
+	src := `.ORIG x3000
+BAD  .FILL 'AB'
+     .FILL #1, '\q'
+     .STRINGZ "a\qb"
+     .FILL #999999999999999999999
+.END
+`
+	p, err := ParseBytes("a.asm", []byte(src))
+	require.Error(t, err)
+
+	var got []string
+	for _, e := range err.(ErrorList) {
+		got = append(got, e.Error())
+	}
+	assert.Equal(t, []string{
+		"a.asm:2:12: character literal 'AB' must hold a single character",
+		"a.asm:3:16: unknown escape sequence '\\q' in character '\\q'",
+		"a.asm:4:15: unknown escape sequence '\\q' in string",
+		"a.asm:5:12: number #999999999999999999999 is out of range",
+	}, got)
+
+	// the label of the broken line is still defined
+	require.Len(t, p.Statements, 3)
+	assert.Equal(t, "BAD", *p.Statements[1].Labels[0].Name)
+}
+
+func TestSuggest(t *testing.T) {
+	names := []string{"LOOP", "DONE", "LOOP2"}
+
+	assert.Equal(t, "LOOP", Suggest("LOPO", names))
+	assert.Equal(t, "LOOP", Suggest("loop", names))
+	assert.Equal(t, "DONE", Suggest("DONR", names))
+	assert.Equal(t, "", Suggest("START", names))
+	assert.Equal(t, "", Suggest("LOOP", []string{"LOOP"}))
+}
+
+func TestIsLabel(t *testing.T) {
+	assert.True(t, IsLabel("LOOP"))
+	assert.True(t, IsLabel("loop_2"))
+	assert.False(t, IsLabel("ADD"))
+	assert.False(t, IsLabel("R1"))
+	assert.False(t, IsLabel("x10"))
+	assert.False(t, IsLabel(".FILL"))
+}
+
+func TestParse_LocalLabels(t *testing.T) {
+	p, err := Parse(strings.NewReader("1: ADD R0, R0, #-1\nLOOP 20: BRp 1b\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, LocalLabel(1), *p.Statements[0].Labels[0].Local)
+	assert.Equal(t, "LOOP", *p.Statements[1].Labels[0].Name)
+	assert.Equal(t, LocalLabel(20), *p.Statements[1].Labels[1].Local)
+	assert.Equal(t, "1b", *p.Statements[1].Op.Args[0].Expr.Left.Left.Operand.Label)
+
+	_, err = Parse(strings.NewReader("x1: RET\n"))
+	assert.Error(t, err)
+}
+
+func TestLocalRef(t *testing.T) {
+	n, forward, ok := LocalRef("12f")
+	assert.True(t, ok)
+	assert.True(t, forward)
+	assert.Equal(t, LocalLabel(12), n)
+
+	n, forward, ok = LocalRef("3b")
+	assert.True(t, ok)
+	assert.False(t, forward)
+	assert.Equal(t, LocalLabel(3), n)
+
+	for _, s := range []string{"b", "1", "1x", "a1b", "1bf"} {
+		_, _, ok := LocalRef(s)
+		assert.False(t, ok, s)
+	}
+}
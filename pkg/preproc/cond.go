@@ -0,0 +1,95 @@
+package preproc
+
+import (
+	"strings"
+
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+var conditionals = map[string]bool{
+	".if":     true,
+	".ifdef":  true,
+	".ifndef": true,
+	".else":   true,
+	".endif":  true,
+}
+
+// cond is an open conditional block
+type cond struct {
+	pos lexer.Position
+	// outer tells whether the enclosing block is kept
+	outer bool
+	// taken tells whether the condition holds, active is the branch being
+	// kept
+	taken, active bool
+	inElse        bool
+}
+
+func (p *preprocessor) skipping() bool {
+	return len(p.conds) != 0 && !p.conds[len(p.conds)-1].active
+}
+
+func (p *preprocessor) conditional(l parser.Line, words []word) error {
+	name := strings.ToLower(words[0].text)
+	rest := strings.TrimSpace(code(l.Text)[words[0].end:])
+
+	switch name {
+	case ".else", ".endif":
+		if len(p.conds) == 0 {
+			return participle.Errorf(l.Pos, "%s without .IF", strings.ToUpper(name))
+		}
+		if rest != "" {
+			return participle.Errorf(l.Pos, "%s takes no arguments", strings.ToUpper(name))
+		}
+		c := p.conds[len(p.conds)-1]
+		if name == ".endif" {
+			p.conds = p.conds[:len(p.conds)-1]
+			return nil
+		}
+		if c.inElse {
+			return participle.Errorf(l.Pos, "duplicate .ELSE for .IF at %s", c.pos)
+		}
+		c.inElse = true
+		c.active = c.outer && !c.taken
+		return nil
+	}
+
+	c := &cond{pos: l.Pos, outer: !p.skipping()}
+	p.conds = append(p.conds, c)
+	if !c.outer {
+		// conditions of dropped blocks are not evaluated
+		return nil
+	}
+
+	switch name {
+	case ".ifdef", ".ifndef":
+		if len(words) != 2 || !parser.IsLabel(words[1].text) {
+			return participle.Errorf(l.Pos, "%s expects a symbol name", strings.ToUpper(name))
+		}
+		_, defined := p.defines[words[1].text]
+		c.taken = defined == (name == ".ifdef")
+	default:
+		v, err := p.eval(rest)
+		if err != nil {
+			return participle.Errorf(l.Pos, ".IF %s: %s", rest, err)
+		}
+		c.taken = v != 0
+	}
+	c.active = c.taken
+
+	return nil
+}
+
+// defineSymbol handles .DEFINE NAME [value]
+func (p *preprocessor) defineSymbol(l parser.Line, words []word) error {
+	if len(words) < 2 || !parser.IsLabel(words[1].text) {
+		return participle.Errorf(l.Pos, ".DEFINE expects a symbol name")
+	}
+
+	p.defines[words[1].text] = strings.TrimSpace(code(l.Text)[words[1].end:])
+
+	return nil
+}
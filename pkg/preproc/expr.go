@@ -0,0 +1,240 @@
+package preproc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+// binaryOps lists binary operators of .IF expressions by precedence, from the
+// loosest binding to the tightest one
+var binaryOps = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// evaluator computes .IF expressions. Operands are numbers and defined
+// symbols, defined(NAME) tells whether NAME is defined at all. Comparisons
+// and logical operators give 1 for true and 0 for false.
+type evaluator struct {
+	defines map[string]string
+	tokens  []string
+}
+
+func (p *preprocessor) eval(expr string) (int, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return 0, err
+	}
+	if len(tokens) == 0 {
+		return 0, fmt.Errorf("expression expected")
+	}
+
+	e := evaluator{defines: p.defines, tokens: tokens}
+	v, err := e.binary(0)
+	if err != nil {
+		return 0, err
+	}
+	if len(e.tokens) != 0 {
+		return 0, fmt.Errorf("unexpected '%s'", e.tokens[0])
+	}
+
+	return v, nil
+}
+
+func (e *evaluator) binary(level int) (int, error) {
+	if level == len(binaryOps) {
+		return e.unary()
+	}
+
+	lhs, err := e.binary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for len(e.tokens) != 0 && contains(binaryOps[level], e.tokens[0]) {
+		op := e.next()
+		rhs, err := e.binary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		if lhs, err = apply(op, lhs, rhs); err != nil {
+			return 0, err
+		}
+	}
+
+	return lhs, nil
+}
+
+func (e *evaluator) unary() (int, error) {
+	switch tok := e.next(); {
+	case tok == "":
+		return 0, fmt.Errorf("unexpected end of expression")
+	case tok == "!" || tok == "-":
+		v, err := e.unary()
+		if err != nil {
+			return 0, err
+		}
+		if tok == "-" {
+			return -v, nil
+		}
+		return truth(v == 0), nil
+	case tok == "(":
+		v, err := e.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		if e.next() != ")" {
+			return 0, fmt.Errorf("')' expected")
+		}
+		return v, nil
+	case tok == "defined":
+		if e.next() != "(" {
+			return 0, fmt.Errorf("'(' expected after defined")
+		}
+		name := e.next()
+		if e.next() != ")" {
+			return 0, fmt.Errorf("')' expected")
+		}
+		_, ok := e.defines[name]
+		return truth(ok), nil
+	case isWordChar(tok[0]) || tok[0] == '#' || tok[0] == '\'':
+		return e.operand(tok)
+	default:
+		return 0, fmt.Errorf("unexpected '%s'", tok)
+	}
+}
+
+func (e *evaluator) operand(tok string) (int, error) {
+	if v, ok := e.defines[tok]; ok {
+		if v = strings.TrimSpace(v); v == "" {
+			return 1, nil
+		}
+		var n parser.Number
+		if err := n.Capture([]string{v}); err != nil {
+			return 0, fmt.Errorf("symbol %s is not a number: '%s'", tok, v)
+		}
+		return int(n), nil
+	}
+
+	var n parser.Number
+	if err := n.Capture([]string{tok}); err != nil {
+		if parser.IsLabel(tok) {
+			return 0, fmt.Errorf("undefined symbol '%s'", tok)
+		}
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+func (e *evaluator) next() string {
+	if len(e.tokens) == 0 {
+		return ""
+	}
+	tok := e.tokens[0]
+	e.tokens = e.tokens[1:]
+
+	return tok
+}
+
+func apply(op string, lhs, rhs int) (int, error) {
+	switch op {
+	case "||":
+		return truth(lhs != 0 || rhs != 0), nil
+	case "&&":
+		return truth(lhs != 0 && rhs != 0), nil
+	case "==":
+		return truth(lhs == rhs), nil
+	case "!=":
+		return truth(lhs != rhs), nil
+	case "<":
+		return truth(lhs < rhs), nil
+	case "<=":
+		return truth(lhs <= rhs), nil
+	case ">":
+		return truth(lhs > rhs), nil
+	case ">=":
+		return truth(lhs >= rhs), nil
+	case "+":
+		return lhs + rhs, nil
+	case "-":
+		return lhs - rhs, nil
+	case "*":
+		return lhs * rhs, nil
+	}
+
+	if rhs == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	if op == "/" {
+		return lhs / rhs, nil
+	}
+
+	return lhs % rhs, nil
+}
+
+// tokenize splits an expression into operands, operators and parentheses
+func tokenize(expr string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '\'':
+			j := i + 1
+			for j < len(expr) && expr[j] != '\'' {
+				if expr[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf("unterminated character literal")
+			}
+			tokens = append(tokens, expr[i:j+1])
+			i = j + 1
+		case isWordChar(c) || c == '#':
+			j := i + 1
+			for j < len(expr) && (isWordChar(expr[j]) || expr[j] == '-' && expr[j-1] == '#') {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		case i+1 < len(expr) && contains([]string{"||", "&&", "==", "!=", "<=", ">="}, expr[i:i+2]):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case strings.IndexByte("!<>+-*/%()", c) != -1:
+			tokens = append(tokens, expr[i:i+1])
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character '%c'", c)
+		}
+	}
+
+	return tokens, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func truth(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,79 @@
+package preproc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/participle/v2"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+// Resolver locates a file named by .INCLUDE within the file from and returns
+// its path and contents
+type Resolver func(name, from string) (string, []byte, error)
+
+// DirResolver looks for included files next to the including file first and
+// then in dirs in order
+func DirResolver(dirs ...string) Resolver {
+	return func(name, from string) (string, []byte, error) {
+		if filepath.IsAbs(name) {
+			src, err := os.ReadFile(name)
+			return name, src, err
+		}
+
+		for _, dir := range append([]string{filepath.Dir(from)}, dirs...) {
+			path := filepath.Join(dir, name)
+			src, err := os.ReadFile(path)
+			if err == nil {
+				return path, src, nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return "", nil, err
+			}
+		}
+
+		return "", nil, fmt.Errorf("file not found")
+	}
+}
+
+// include processes the file named by an .INCLUDE line in place
+func (p *preprocessor) include(l parser.Line, words []word) error {
+	if len(words) != 2 || !strings.HasPrefix(words[1].text, `"`) || !strings.HasSuffix(words[1].text, `"`) ||
+		len(words[1].text) < 3 {
+		return participle.Errorf(l.Pos, ".INCLUDE expects a single file name in quotes")
+	}
+	name := words[1].text[1 : len(words[1].text)-1]
+
+	path, src, err := p.resolve(name, l.Pos.Filename)
+	if err != nil {
+		return participle.Errorf(l.Pos, "cannot include '%s': %s", name, err)
+	}
+
+	key := fileKey(path)
+	for i, f := range p.files {
+		if fileKey(f) == key {
+			chain := append(append([]string{}, p.files[i:]...), path)
+			return participle.Errorf(l.Pos, "include cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	p.files = append(p.files, path)
+	defer func() { p.files = p.files[:len(p.files)-1] }()
+	p.process(parser.SplitLines(path, src))
+
+	return nil
+}
+
+// fileKey identifies a file regardless of the path it was reached by
+func fileKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+
+	return filepath.Clean(path)
+}
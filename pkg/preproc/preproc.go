@@ -0,0 +1,350 @@
+package preproc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+// maxDepth limits nested macro expansion, deeper nesting means recursion
+const maxDepth = 64
+
+type macro struct {
+	pos    lexer.Position
+	name   string
+	params []string
+	body   []parser.Line
+	// labels are defined within the body, they are renamed in every
+	// expansion to stay unique
+	labels map[string]bool
+	// broken definitions have their body skipped but are not defined
+	broken bool
+}
+
+type preprocessor struct {
+	macros map[string]*macro
+	// expansions counts macro invocations to generate unique label names
+	expansions int
+	out        []parser.Line
+	// def is the macro being defined, its body is collected until .ENDM
+	def *macro
+
+	defines map[string]string
+	conds   []*cond
+
+	resolve Resolver
+	// files is the stack of files being processed, used to detect include
+	// cycles
+	files []string
+
+	errs parser.ErrorList
+}
+
+type Option func(p *preprocessor)
+
+// WithResolver sets the way .INCLUDE files are found, DirResolver without
+// search paths is used by default
+func WithResolver(r Resolver) Option {
+	return func(p *preprocessor) {
+		p.resolve = r
+	}
+}
+
+// WithIncludeDirs adds search paths for .INCLUDE files
+func WithIncludeDirs(dirs ...string) Option {
+	return WithResolver(DirResolver(dirs...))
+}
+
+// WithDefine defines a symbol as if the source started with .DEFINE name value
+func WithDefine(name, value string) Option {
+	return func(p *preprocessor) {
+		p.defines[name] = value
+	}
+}
+
+// Process runs the preprocessor over src and returns the resulting lines
+// ready for parser.ParseLines. It handles macro definitions:
+//
+//	.MACRO name param1, param2
+//	    ...
+//	.ENDM
+//
+// Invocations "name arg1, arg2" are replaced with the macro body where
+// parameters are substituted with arguments and labels defined in the body
+// are made unique per expansion.
+//
+// Lines of files pulled in with .INCLUDE "file.asm" keep positions within
+// those files.
+//
+// Conditional blocks are kept or dropped depending on defined symbols:
+//
+//	.DEFINE NAME value
+//	.IFDEF NAME / .IFNDEF NAME / .IF expression
+//	    ...
+//	.ELSE
+//	    ...
+//	.ENDIF
+//
+// Dropped lines are still lexed but produce neither code nor labels. Defined
+// symbols are replaced with their values in the code that follows.
+//
+// Processing goes on past errors to find the rest of them, they are returned
+// as a parser.ErrorList along with the lines produced.
+func Process(filename string, src []byte, opts ...Option) ([]parser.Line, error) {
+	p := preprocessor{
+		macros:  map[string]*macro{},
+		defines: map[string]string{},
+		resolve: DirResolver(),
+		files:   []string{filename},
+	}
+	for _, opt := range opts {
+		opt(&p)
+	}
+
+	p.process(parser.SplitLines(filename, src))
+
+	return p.out, p.errs.Err()
+}
+
+// Directives returns names of the directives the preprocessor handles
+func Directives() []string {
+	names := []string{".MACRO", ".ENDM", ".INCLUDE", ".DEFINE"}
+	for n := range conditionals {
+		names = append(names, strings.ToUpper(n))
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Write writes the text of preprocessed lines
+func Write(w io.Writer, lines []parser.Line) error {
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(w, l.Text); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// process handles lines of a single file, macro definitions and conditional
+// blocks must not span files
+func (p *preprocessor) process(lines []parser.Line) {
+	conds := len(p.conds)
+
+	for _, l := range lines {
+		p.fail(l.Pos, p.line(l, 0))
+	}
+
+	if p.def != nil {
+		p.fail(p.def.pos, fmt.Errorf(".MACRO %s is missing .ENDM", p.def.name))
+		p.def = nil
+	}
+	if len(p.conds) > conds {
+		p.fail(p.conds[len(p.conds)-1].pos, errors.New(".ENDIF expected"))
+		p.conds = p.conds[:conds]
+	}
+}
+
+// fail records an error at pos unless it has a position of its own,
+// processing goes on to find more of them
+func (p *preprocessor) fail(pos lexer.Position, err error) {
+	if err == nil {
+		return
+	}
+	perr, ok := err.(participle.Error)
+	if !ok {
+		perr = participle.Errorf(pos, "%s", err)
+	}
+	p.errs = append(p.errs, perr)
+}
+
+// line handles a single line, depth is the macro expansion nesting it comes
+// from
+func (p *preprocessor) line(l parser.Line, depth int) error {
+	words := splitWords(code(l.Text))
+	first := ""
+	if len(words) > 0 {
+		first = strings.ToLower(words[0].text)
+	}
+
+	switch {
+	case p.def != nil:
+		return p.body(l, first)
+	case conditionals[first]:
+		return p.conditional(l, words)
+	case p.skipping():
+		_, err := parser.Lex(l)
+		return err
+	case first == ".endm":
+		return participle.Errorf(l.Pos, ".ENDM without .MACRO")
+	case first == ".macro" && depth > 0:
+		return participle.Errorf(l.Pos, ".MACRO is not allowed within a macro")
+	case first == ".macro":
+		// the body of a broken definition is still skipped up to .ENDM
+		m, err := p.define(l, words)
+		m.broken = err != nil
+		p.def = m
+		return err
+	case first == ".include" && depth > 0:
+		return participle.Errorf(l.Pos, ".INCLUDE is not allowed within a macro")
+	case first == ".include":
+		return p.include(l, words)
+	case first == ".define":
+		return p.defineSymbol(l, words)
+	}
+
+	if len(p.defines) != 0 {
+		l.Text = substitute(l.Text, p.defines)
+	}
+
+	return p.expand(l, depth)
+}
+
+// body collects lines of the macro being defined
+func (p *preprocessor) body(l parser.Line, first string) error {
+	switch first {
+	case ".endm":
+		if !p.def.broken {
+			for _, bl := range p.def.body {
+				for _, label := range p.definedLabels(p.def, bl) {
+					p.def.labels[label] = true
+				}
+			}
+			p.macros[strings.ToLower(p.def.name)] = p.def
+		}
+		p.def = nil
+	case ".macro":
+		return participle.Errorf(l.Pos, "nested .MACRO definitions are not allowed")
+	default:
+		p.def.body = append(p.def.body, l)
+	}
+
+	return nil
+}
+
+// define starts a macro definition, the macro is returned even if the
+// definition is broken
+func (p *preprocessor) define(l parser.Line, words []word) (*macro, error) {
+	m := &macro{pos: l.Pos, labels: map[string]bool{}}
+	if len(words) < 2 {
+		return m, participle.Errorf(l.Pos, ".MACRO expects a name")
+	}
+
+	m.name = words[1].text
+	if !parser.IsLabel(m.name) {
+		return m, participle.Errorf(l.Pos, "invalid macro name '%s'", m.name)
+	}
+	if prev, ok := p.macros[strings.ToLower(m.name)]; ok {
+		return m, participle.Errorf(l.Pos, "macro %s is already defined at %s", m.name, prev.pos)
+	}
+
+	for _, param := range splitArgs(code(l.Text)[words[1].end:]) {
+		if !parser.IsLabel(param) {
+			return m, participle.Errorf(l.Pos, "invalid macro parameter '%s'", param)
+		}
+		m.params = append(m.params, param)
+	}
+
+	return m, nil
+}
+
+// expand copies l to the output, expanding it when it invokes a macro
+func (p *preprocessor) expand(l parser.Line, depth int) error {
+	labels, m, args := p.invocation(l)
+	if m == nil {
+		p.out = append(p.out, l)
+		return nil
+	}
+
+	if depth >= maxDepth {
+		return participle.Errorf(l.Pos, "macro %s expansion is too deep, recursive invocation?", m.name)
+	}
+	if len(args) != len(m.params) {
+		return participle.Errorf(l.Pos, "macro %s expects %d argument(s), got %d", m.name, len(m.params), len(args))
+	}
+
+	p.expansions++
+	repl := map[string]string{}
+	for label := range m.labels {
+		repl[label] = fmt.Sprintf("%s__%d", label, p.expansions)
+	}
+	for i, param := range m.params {
+		repl[param] = args[i]
+	}
+
+	if len(labels) != 0 {
+		p.out = append(p.out, parser.Line{Pos: l.Pos, Text: strings.Join(labels, " "), Expanded: true})
+	}
+	conds := len(p.conds)
+	for _, bl := range m.body {
+		expanded := parser.Line{Pos: l.Pos, Text: substitute(bl.Text, repl), Expanded: true}
+		p.fail(l.Pos, p.line(expanded, depth+1))
+	}
+	if len(p.conds) != conds {
+		if len(p.conds) > conds {
+			p.conds = p.conds[:conds]
+		}
+		return participle.Errorf(l.Pos, "macro %s has unbalanced conditional blocks", m.name)
+	}
+
+	return nil
+}
+
+// invocation returns leading labels, the macro and its arguments when l
+// invokes a macro
+func (p *preprocessor) invocation(l parser.Line) ([]string, *macro, []string) {
+	c := code(l.Text)
+	words := splitWords(c)
+
+	var labels []string
+	for _, w := range words {
+		if m, ok := p.macros[strings.ToLower(w.text)]; ok {
+			return labels, m, splitArgs(c[w.end:])
+		}
+		name := strings.TrimSuffix(w.text, ":")
+		local := name != w.text && parser.IsLocalLabel(name)
+		if !parser.IsLabel(name) && !local {
+			break
+		}
+		labels = append(labels, w.text)
+	}
+
+	return nil, nil, nil
+}
+
+// definedLabels returns labels a body line of m defines, these are the
+// leading words not recognized as instructions, directives or macros.
+// Parameters are skipped as they name labels given by the invocation.
+func (p *preprocessor) definedLabels(m *macro, l parser.Line) []string {
+	var labels []string
+	for _, w := range splitWords(code(l.Text)) {
+		name := strings.TrimSuffix(w.text, ":")
+		if _, ok := p.macros[strings.ToLower(name)]; ok || strings.EqualFold(name, m.name) || !parser.IsLabel(name) {
+			break
+		}
+		if !m.isParam(name) {
+			labels = append(labels, name)
+		}
+	}
+
+	return labels
+}
+
+func (m *macro) isParam(name string) bool {
+	for _, param := range m.params {
+		if param == name {
+			return true
+		}
+	}
+
+	return false
+}
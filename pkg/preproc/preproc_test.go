@@ -0,0 +1,310 @@
+package preproc
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-medvedchikov/lc3/pkg/parser"
+)
+
+func text(t *testing.T, lines []parser.Line) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, lines))
+
+	return buf.String()
+}
+
+func TestProcess(t *testing.T) {
+	lines, err := Process("test.asm", []byte(`.MACRO PUSH reg ; push a register
+        ADD R6, R6, #-1
+        STR reg, R6, #0
+.ENDM
+.macro WAIT dev
+LOOP    LDI R1, dev
+        BRzp LOOP
+.endm
+        .ORIG x3000
+START   PUSH R1     ; save R1
+        WAIT KBSR
+        WAIT DSR
+        .END
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, `        .ORIG x3000
+START
+        ADD R6, R6, #-1
+        STR R1, R6, #0
+LOOP__2    LDI R1, KBSR
+        BRzp LOOP__2
+LOOP__3    LDI R1, DSR
+        BRzp LOOP__3
+        .END
+`, text(t, lines))
+
+	invocation := lexer.Position{Filename: "test.asm", Offset: 168, Line: 10, Column: 1}
+	assert.Equal(t, parser.Line{Pos: invocation, Text: "START", Expanded: true}, lines[1])
+	assert.Equal(t, invocation, lines[2].Pos)
+	assert.Equal(t, 9, lines[0].Pos.Line)
+	assert.Equal(t, 13, lines[8].Pos.Line)
+}
+
+func TestProcess_Nested(t *testing.T) {
+	lines, err := Process("", []byte(`.MACRO CLR reg
+        AND reg, reg, #0
+.ENDM
+.MACRO CLR2 a, b
+        CLR a
+        CLR b
+.ENDM
+        CLR2 R1, R2
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, `        AND R1, R1, #0
+        AND R2, R2, #0
+`, text(t, lines))
+}
+
+func TestProcess_Strings(t *testing.T) {
+	lines, err := Process("", []byte(`.MACRO MSG name, s
+name    .STRINGZ s ; name
+        .STRINGZ "name, s"
+.ENDM
+        MSG HELLO, "Hi, \"you\""
+        MSG SEMI, ';' ; semicolon
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, `HELLO    .STRINGZ "Hi, \"you\"" ; name
+        .STRINGZ "name, s"
+SEMI    .STRINGZ ';' ; name
+        .STRINGZ "name, s"
+`, text(t, lines))
+}
+
+func TestProcess_Errors(t *testing.T) {
+	tests := map[string]string{
+		"missing endm":   ".MACRO A\nADD R0, R0, R0\n",
+		"stray endm":     ".ENDM\n",
+		"nested":         ".MACRO A\n.MACRO B\n.ENDM\n.ENDM\n",
+		"no name":        ".MACRO\n.ENDM\n",
+		"mnemonic name":  ".MACRO ADD\n.ENDM\n",
+		"bad param":      ".MACRO A R1\n.ENDM\n",
+		"redefined":      ".MACRO A\n.ENDM\n.MACRO A\n.ENDM\n",
+		"argument count": ".MACRO A x\n.ENDM\nA 1, 2\n",
+		"recursion":      ".MACRO A\nA\n.ENDM\nA\n",
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Process("", []byte(src))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestProcess_AllErrors(t *testing.T) {
+	src := `.ENDIF
+.IF 1 +
+ADD R0, R0, R0
+.ENDIF
+.MACRO R1
+.ENDM
+.MACRO M x
+ADD x, x, x
+.ENDM
+M 1, 2
+HALT
+`
+	lines, err := Process("a.asm", []byte(src))
+	require.Error(t, err)
+
+	errs := err.(parser.ErrorList)
+	var got []string
+	for _, e := range errs {
+		got = append(got, fmt.Sprintf("%d: %s", e.Position().Line, e.Message()))
+	}
+	require.Len(t, got, 4)
+	assert.Equal(t, "1: .ENDIF without .IF", got[0])
+	assert.True(t, strings.HasPrefix(got[1], "2: .IF 1 +: "), got[1])
+	// the body of the broken definition is skipped without more errors
+	assert.Equal(t, "5: invalid macro name 'R1'", got[2])
+	assert.Equal(t, "10: macro M expects 1 argument(s), got 2", got[3])
+
+	// lines past the errors are still processed
+	require.NotEmpty(t, lines)
+	assert.Equal(t, "HALT", lines[len(lines)-1].Text)
+}
+
+func mapResolver(files map[string]string) Resolver {
+	return func(name, from string) (string, []byte, error) {
+		src, ok := files[name]
+		if !ok {
+			return "", nil, fmt.Errorf("file not found")
+		}
+		return name, []byte(src), nil
+	}
+}
+
+func TestProcess_Include(t *testing.T) {
+	resolve := mapResolver(map[string]string{
+		"macros.asm": ".MACRO CLR reg\n        AND reg, reg, #0\n.ENDM\n",
+		"lib.asm":    "; library\nZERO    CLR R0\n        RET\n",
+	})
+
+	lines, err := Process("main.asm", []byte(`.INCLUDE "macros.asm"
+        .ORIG x3000
+        .include "lib.asm" ; routines
+        .END
+`), WithResolver(resolve))
+	require.NoError(t, err)
+
+	assert.Equal(t, `        .ORIG x3000
+; library
+ZERO
+        AND R0, R0, #0
+        RET
+        .END
+`, text(t, lines))
+
+	assert.Equal(t, lexer.Position{Filename: "lib.asm", Offset: 10, Line: 2, Column: 1}, lines[2].Pos)
+	assert.Equal(t, lexer.Position{Filename: "main.asm", Offset: 80, Line: 4, Column: 1}, lines[5].Pos)
+}
+
+func TestProcess_IncludeErrors(t *testing.T) {
+	resolve := mapResolver(map[string]string{
+		"a.asm": `.INCLUDE "b.asm"`,
+		"b.asm": `.INCLUDE "a.asm"`,
+	})
+
+	_, err := Process("main.asm", []byte(`.INCLUDE "a.asm"`), WithResolver(resolve))
+	assert.EqualError(t, err, "b.asm:1:1: include cycle: a.asm -> b.asm -> a.asm")
+
+	_, err = Process("main.asm", []byte("\n.INCLUDE \"c.asm\""), WithResolver(resolve))
+	assert.EqualError(t, err, "main.asm:2:1: cannot include 'c.asm': file not found")
+
+	_, err = Process("main.asm", []byte(`.INCLUDE a.asm`), WithResolver(resolve))
+	assert.Error(t, err)
+
+	_, err = Process("main.asm", []byte(".MACRO A\n.INCLUDE \"a.asm\"\n.ENDM\nA\n"), WithResolver(resolve))
+	assert.Error(t, err)
+}
+
+func TestProcess_Conditionals(t *testing.T) {
+	src := []byte(`.DEFINE LEVEL 2
+.IFDEF DEBUG
+TRACE   OUT
+.IF LEVEL > 1 && !defined(QUIET)
+        PUTS
+.ELSE
+        .FILL LEVEL
+.ENDIF
+.ELSE
+TRACE   RET
+.ENDIF
+.IFNDEF DEBUG
+        .FILL LEVEL ; LEVEL
+.ENDIF
+`)
+
+	lines, err := Process("", src, WithDefine("DEBUG", "1"))
+	require.NoError(t, err)
+	assert.Equal(t, "TRACE   OUT\n        PUTS\n", text(t, lines))
+
+	lines, err = Process("", src, WithDefine("DEBUG", ""), WithDefine("QUIET", "1"))
+	require.NoError(t, err)
+	assert.Equal(t, "TRACE   OUT\n        .FILL 2\n", text(t, lines))
+
+	lines, err = Process("", src)
+	require.NoError(t, err)
+	assert.Equal(t, "TRACE   RET\n        .FILL 2 ; LEVEL\n", text(t, lines))
+}
+
+func TestProcess_ConditionalMacro(t *testing.T) {
+	lines, err := Process("", []byte(`.MACRO LOG msg
+.IFDEF DEBUG
+        LEA R0, msg
+        PUTS
+.ENDIF
+.ENDM
+        LOG HELLO
+.DEFINE DEBUG
+        LOG HELLO
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, "        LEA R0, HELLO\n        PUTS\n", text(t, lines))
+}
+
+func TestProcess_ConditionalErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing endif":   ".IFDEF A\n",
+		"stray endif":     ".ENDIF\n",
+		"stray else":      ".ELSE\n",
+		"duplicate else":  ".IFDEF A\n.ELSE\n.ELSE\n.ENDIF\n",
+		"undefined":       ".IF A > 1\n.ENDIF\n",
+		"bad expression":  ".IF (1\n.ENDIF\n",
+		"no symbol":       ".IFDEF\n.ENDIF\n",
+		"skipped lexing":  ".IFDEF A\n  ADD R0, R0, @\n.ENDIF\n",
+		"unbalanced body": ".MACRO M\n.IFDEF A\n.ENDM\nM\n",
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Process("", []byte(src))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEval(t *testing.T) {
+	p := preprocessor{defines: map[string]string{"A": "x10", "B": "#-2", "C": ""}}
+
+	tests := map[string]int{
+		"1":                    1,
+		"A":                    16,
+		"B + 3 * 2":            4,
+		"(B + 3) * 2":          2,
+		"-A / 3":               -5,
+		"A % 5 == 1":           1,
+		"C && !defined(D)":     1,
+		"A >= 16 || 0":         1,
+		"A < 10 || B != #-2":   0,
+		"defined(A) + A - #16": 1,
+		"'A' == 0x41":          1,
+		"';' + b1":             60,
+	}
+
+	for expr, want := range tests {
+		got, err := p.eval(expr)
+		if assert.NoError(t, err, expr) {
+			assert.Equal(t, want, got, expr)
+		}
+	}
+}
+
+func TestProcess_LocalLabels(t *testing.T) {
+	lines, err := Process("", []byte(`.MACRO WAIT dev
+1:      LDI R1, dev
+        BRzp 1b
+.ENDM
+1:      WAIT KBSR
+        BR 1b
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, `1:
+1:      LDI R1, KBSR
+        BRzp 1b
+        BR 1b
+`, text(t, lines))
+}
@@ -0,0 +1,148 @@
+package preproc
+
+import (
+	"strings"
+)
+
+type word struct {
+	text       string
+	start, end int
+}
+
+// code returns the text of a line without its comment
+func code(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case isQuote(c):
+			quote = toggleQuote(quote, c)
+		case quote == 0 && c == ';':
+			return text[:i]
+		}
+	}
+
+	return text
+}
+
+func isQuote(c byte) bool {
+	return c == '"' || c == '\''
+}
+
+// toggleQuote tracks strings and character literals, quote is the one
+// currently open or 0
+func toggleQuote(quote, c byte) byte {
+	switch quote {
+	case 0:
+		return c
+	case c:
+		return 0
+	}
+
+	return quote
+}
+
+// splitWords splits code into whitespace separated words, commas separate
+// words as well, strings and characters are kept whole
+func splitWords(code string) []word {
+	var words []word
+
+	start := -1
+	var quote byte
+	for i := 0; i <= len(code); i++ {
+		sep := i == len(code)
+		if !sep {
+			switch c := code[i]; {
+			case quote != 0 && c == '\\':
+				i++
+				continue
+			case isQuote(c):
+				quote = toggleQuote(quote, c)
+			case quote == 0:
+				sep = c == ' ' || c == '\t' || c == ','
+			}
+		}
+
+		switch {
+		case sep && start != -1:
+			words = append(words, word{text: code[start:i], start: start, end: i})
+			start = -1
+		case !sep && start == -1:
+			start = i
+		}
+	}
+
+	return words
+}
+
+// splitArgs splits comma separated arguments, strings and characters are
+// kept whole
+func splitArgs(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+
+	var args []string
+	start := 0
+	var quote byte
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			switch c := s[i]; {
+			case quote != 0 && c == '\\':
+				i++
+				continue
+			case isQuote(c):
+				quote = toggleQuote(quote, c)
+				continue
+			case quote != 0 || c != ',':
+				continue
+			}
+		}
+		args = append(args, strings.TrimSpace(s[start:i]))
+		start = i + 1
+	}
+
+	return args
+}
+
+// substitute replaces whole identifiers found in repl within the code part
+// of text, strings, characters and the comment are left as they are
+func substitute(text string, repl map[string]string) string {
+	var b strings.Builder
+
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0 && c == '\\' && i+1 < len(text):
+			b.WriteString(text[i : i+2])
+			i++
+			continue
+		case isQuote(c):
+			quote = toggleQuote(quote, c)
+		case quote == 0 && c == ';':
+			b.WriteString(text[i:])
+			return b.String()
+		case quote == 0 && isWordChar(c) && (i == 0 || !isWordChar(text[i-1])):
+			j := i
+			for j < len(text) && isWordChar(text[j]) {
+				j++
+			}
+			ident := text[i:j]
+			if r, ok := repl[ident]; ok {
+				ident = r
+			}
+			b.WriteString(ident)
+			i = j - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}